@@ -8,17 +8,10 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-// handleLogsAPIRequest processes API requests for log data
+// handleLogsAPIRequest processes API requests for log data. Entries are filtered
+// by the query parameters, and the X-Next-Cursor header holds the cursor of the
+// next page, if there is one.
 func handleLogsAPIRequest(w http.ResponseWriter, r *http.Request, dbLogger *DBLogger) {
-	// Parse limit parameter
-	limitStr := r.URL.Query().Get("limit")
-	limit := 1000 // Default to 1000 entries
-	if limitStr != "" {
-		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
-			limit = parsedLimit
-		}
-	}
-
 	// Parse specific request ID
 	requestID := r.URL.Query().Get("request_id")
 
@@ -31,8 +24,18 @@ func handleLogsAPIRequest(w http.ResponseWriter, r *http.Request, dbLogger *DBLo
 		// Get specific log entry
 		entries, err = dbLogger.GetEntry(requestID)
 	} else {
-		// Get latest entries
-		entries, err = dbLogger.GetEntries(limit)
+		filter, filterErr := parseLogFilter(r)
+		if filterErr != nil {
+			http.Error(w, filterErr.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Get latest matching entries
+		var cursor int64
+		entries, cursor, err = dbLogger.FindEntries(r.Context(), filter)
+		if cursor > 0 {
+			w.Header().Set("X-Next-Cursor", strconv.FormatInt(cursor, 10))
+		}
 	}
 
 	if err != nil {
@@ -51,33 +54,26 @@ func handleLogsAPIRequest(w http.ResponseWriter, r *http.Request, dbLogger *DBLo
 
 // handleStatsAPIRequest processes API requests for statistics data
 func handleStatsAPIRequest(w http.ResponseWriter, r *http.Request, dbLogger *DBLogger) {
-	// Parse period parameter
-	period := r.URL.Query().Get("period")
-	if period == "" {
-		period = "day" // Default to last 24 hours
-	}
+	writeStats(w, r, dbLogger, "day") // Default to last 24 hours
+}
 
-	// Parse window size parameter
-	windowSizeStr := r.URL.Query().Get("window_size")
-	windowSize := 10 // Default to top 10
-	if windowSizeStr != "" {
-		if parsed, err := strconv.Atoi(windowSizeStr); err == nil && parsed > 0 {
-			windowSize = parsed
-		}
+// handleCallbacksAPIRequest processes API requests for callback delivery data
+func handleCallbacksAPIRequest(w http.ResponseWriter, r *http.Request, dbLogger *DBLogger) {
+	limit := 100
+	if parsed, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && parsed > 0 {
+		limit = parsed
 	}
 
-	// Get statistics from database
-	stats, err := dbLogger.getRequestStats(r.Context(), period, windowSize)
+	deliveries, err := dbLogger.GetCallbackDeliveries(limit, r.URL.Query().Get("request_id"))
 	if err != nil {
-		log.Error().Err(err).Str("period", period).Msg("Error retrieving request statistics")
-		http.Error(w, "Error retrieving statistics", http.StatusInternalServerError)
+		log.Error().Err(err).Msg("Error retrieving callback deliveries")
+		http.Error(w, "Error retrieving callback deliveries", http.StatusInternalServerError)
 		return
 	}
 
-	// Return as JSON
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(stats); err != nil {
-		log.Error().Err(err).Msg("Error encoding statistics")
-		http.Error(w, "Error encoding statistics", http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(deliveries); err != nil {
+		log.Error().Err(err).Msg("Error encoding callback deliveries")
+		http.Error(w, "Error encoding callback deliveries", http.StatusInternalServerError)
 	}
 }
@@ -0,0 +1,678 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Authentication methods, forwarded to backends as the method of the identity
+const (
+	AuthMethodAPIKey  = "api_key"
+	AuthMethodJWT     = "jwt"
+	AuthMethodWebhook = "webhook"
+)
+
+// Webhook signature schemes
+const (
+	WebhookSchemeGitHub = "github" // X-Hub-Signature-256: sha256=<HMAC-SHA256 of the body>
+	WebhookSchemeStripe = "stripe" // Stripe-Signature: t=<unix time>,v1=<HMAC-SHA256 of "<t>.<body>">
+	WebhookSchemeHMAC   = "hmac"   // A configured header with the HMAC-SHA256 of the body
+)
+
+// defaultWebhookTolerance is how many seconds the timestamp of a Stripe signature may deviate
+const defaultWebhookTolerance = 300
+
+// AuthConfig defines how requests to the proxy are authenticated. A request must
+// present an API key, a JWT bearer token or a webhook signature, unless it matches
+// the public scope. Without an auth file, all requests are accepted.
+type AuthConfig struct {
+	APIKeyHeader string     `json:"api_key_header,omitempty"` // Header carrying API keys, X-API-Key by default
+	APIKeys      []*APIKey  `json:"api_keys,omitempty"`
+	JWT          *JWTConfig `json:"jwt,omitempty"`
+	Webhooks     []*Webhook `json:"webhooks,omitempty"`
+	Public       *AuthScope `json:"public,omitempty"` // Requests accepted without credentials
+}
+
+// AuthScope restricts the requests a credential is accepted for. A request is in
+// scope if its route name or its topic is listed; an empty scope contains all requests.
+type AuthScope struct {
+	Routes []string `json:"routes,omitempty"` // Route names of the routing table
+	Topics []string `json:"topics,omitempty"` // Topic patterns, * matches any characters
+}
+
+// APIKey is a static key sent in the API key header
+type APIKey struct {
+	Name      string                 `json:"name"`
+	Key       string                 `json:"key,omitempty"`
+	KeySHA256 string                 `json:"key_sha256,omitempty"` // Hex SHA-256 of the key, keeps the key itself out of the file
+	Claims    map[string]interface{} `json:"claims,omitempty"`     // Forwarded to backends with the identity
+	AuthScope
+
+	hash []byte
+}
+
+// JWTConfig verifies bearer tokens signed with a shared secret or with one of the
+// keys of a JSON Web Key Set
+type JWTConfig struct {
+	Secret      string `json:"secret,omitempty"`       // Shared secret of HS256, HS384 and HS512 tokens
+	JWKSFile    string `json:"jwks_file,omitempty"`    // Public keys of RS, PS, ES and EdDSA tokens
+	Issuer      string `json:"issuer,omitempty"`       // Required iss claim
+	Audience    string `json:"audience,omitempty"`     // Required aud claim
+	TopicsClaim string `json:"topics_claim,omitempty"` // Claim listing the topic patterns a token is accepted for, empty accepts any topic
+	Leeway      int    `json:"leeway,omitempty"`       // Seconds of clock skew tolerated for exp and nbf
+
+	keys    []jsonWebKey
+	methods []string
+}
+
+// Webhook verifies requests signed with a shared secret, as sent by GitHub, Stripe
+// and similar services
+type Webhook struct {
+	Name      string `json:"name"`
+	Scheme    string `json:"scheme"` // github, stripe or hmac
+	Secret    string `json:"secret"`
+	Header    string `json:"header,omitempty"`    // Signature header, required for hmac
+	Tolerance int    `json:"tolerance,omitempty"` // Seconds a stripe timestamp may deviate, 300 by default
+	AuthScope
+}
+
+// Identity is a verified caller. It is forwarded to backends in the auth header
+// of messages, so that they don't need to verify credentials themselves.
+type Identity struct {
+	Method  string                 `json:"method"`
+	Subject string                 `json:"subject"` // Name of the API key or webhook, or sub claim of the token
+	Claims  map[string]interface{} `json:"claims,omitempty"`
+}
+
+// jsonWebKey is a public key of a JSON Web Key Set
+type jsonWebKey struct {
+	id        string
+	algorithm string
+	key       interface{}
+}
+
+// LoadAuthConfig reads and validates the authentication settings from a JSON file
+func LoadAuthConfig(path string) (*AuthConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth file: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var auth AuthConfig
+	if err := decoder.Decode(&auth); err != nil {
+		return nil, fmt.Errorf("invalid auth file %s: %w", path, err)
+	}
+	if err := auth.compile(); err != nil {
+		return nil, fmt.Errorf("invalid auth file %s: %w", path, err)
+	}
+	return &auth, nil
+}
+
+// compile validates the authentication settings and loads the keys they refer to
+func (a *AuthConfig) compile() error {
+	if a.APIKeyHeader == "" {
+		a.APIKeyHeader = "X-API-Key"
+	}
+	if a.Public != nil && a.Public.empty() {
+		return errors.New("public: routes or topics must be set")
+	}
+
+	names := make(map[string]bool)
+	for i, key := range a.APIKeys {
+		if key == nil || key.Name == "" {
+			return fmt.Errorf("api key %d: name must be set", i+1)
+		}
+		if names[key.Name] {
+			return fmt.Errorf("api key %s: duplicate name", key.Name)
+		}
+		names[key.Name] = true
+
+		switch {
+		case key.Key != "" && key.KeySHA256 != "":
+			return fmt.Errorf("api key %s: key and key_sha256 cannot both be set", key.Name)
+		case key.Key != "":
+			hash := sha256.Sum256([]byte(key.Key))
+			key.hash = hash[:]
+		case key.KeySHA256 != "":
+			hash, err := hex.DecodeString(key.KeySHA256)
+			if err != nil || len(hash) != sha256.Size {
+				return fmt.Errorf("api key %s: key_sha256 must be a hex SHA-256 hash", key.Name)
+			}
+			key.hash = hash
+		default:
+			return fmt.Errorf("api key %s: key or key_sha256 must be set", key.Name)
+		}
+	}
+
+	if a.JWT != nil {
+		if err := a.JWT.compile(); err != nil {
+			return fmt.Errorf("jwt: %w", err)
+		}
+	}
+
+	names = make(map[string]bool)
+	for i, webhook := range a.Webhooks {
+		if webhook == nil || webhook.Name == "" {
+			return fmt.Errorf("webhook %d: name must be set", i+1)
+		}
+		if names[webhook.Name] {
+			return fmt.Errorf("webhook %s: duplicate name", webhook.Name)
+		}
+		names[webhook.Name] = true
+
+		switch webhook.Scheme {
+		case WebhookSchemeGitHub, WebhookSchemeStripe:
+		case WebhookSchemeHMAC:
+			if webhook.Header == "" {
+				return fmt.Errorf("webhook %s: header must be set for the hmac scheme", webhook.Name)
+			}
+		default:
+			return fmt.Errorf("webhook %s: unknown scheme %q, expected %s, %s or %s",
+				webhook.Name, webhook.Scheme, WebhookSchemeGitHub, WebhookSchemeStripe, WebhookSchemeHMAC)
+		}
+		if webhook.Secret == "" {
+			return fmt.Errorf("webhook %s: secret must be set", webhook.Name)
+		}
+		if webhook.Tolerance < 0 {
+			return fmt.Errorf("webhook %s: tolerance must not be negative", webhook.Name)
+		}
+	}
+	return nil
+}
+
+// checkRoutes reports route names that don't exist in the routing table
+func (a *AuthConfig) checkRoutes(routes *RouteTable) error {
+	known := make(map[string]bool)
+	if routes != nil {
+		for _, route := range routes.Routes {
+			known[route.Name] = true
+		}
+	}
+
+	var errs []error
+	check := func(owner string, scope AuthScope) {
+		for _, name := range scope.Routes {
+			if !known[name] {
+				errs = append(errs, fmt.Errorf("%s: unknown route %q", owner, name))
+			}
+		}
+	}
+	for _, key := range a.APIKeys {
+		check("api key "+key.Name, key.AuthScope)
+	}
+	for _, webhook := range a.Webhooks {
+		check("webhook "+webhook.Name, webhook.AuthScope)
+	}
+	if a.Public != nil {
+		check("public", *a.Public)
+	}
+	return errors.Join(errs...)
+}
+
+// files returns the files the authentication settings were loaded from, besides the auth file
+func (a *AuthConfig) files() []string {
+	if a.JWT != nil && a.JWT.JWKSFile != "" {
+		return []string{a.JWT.JWKSFile}
+	}
+	return nil
+}
+
+// Authenticate verifies the credentials of a request to a route and topic. It
+// returns the identity of the caller, which is nil for public requests, or the
+// status code and reason of the rejection: 401 for missing or invalid credentials
+// and 403 for credentials that are not accepted for the route or topic.
+func (a *AuthConfig) Authenticate(r *http.Request, body []byte, route, topic string) (*Identity, int, error) {
+	if value := r.Header.Get(a.APIKeyHeader); value != "" {
+		key := a.findAPIKey(value)
+		if key == nil {
+			return nil, http.StatusUnauthorized, errors.New("invalid API key")
+		}
+		if !key.allows(route, topic) {
+			return nil, http.StatusForbidden, fmt.Errorf("API key %s is not allowed to publish to %s", key.Name, topic)
+		}
+		return &Identity{Method: AuthMethodAPIKey, Subject: key.Name, Claims: key.Claims}, 0, nil
+	}
+
+	if token, ok := bearerToken(r); ok && a.JWT != nil {
+		claims, err := a.JWT.verify(token)
+		if err != nil {
+			return nil, http.StatusUnauthorized, fmt.Errorf("invalid token: %w", err)
+		}
+		if !a.JWT.allows(claims, topic) {
+			return nil, http.StatusForbidden, fmt.Errorf("token is not allowed to publish to %s", topic)
+		}
+		subject, _ := claims.GetSubject()
+		return &Identity{Method: AuthMethodJWT, Subject: subject, Claims: claims}, 0, nil
+	}
+
+	// Several webhooks may share a header, e.g. while a secret is rotated
+	var signatureErr error
+	for _, webhook := range a.Webhooks {
+		signature := r.Header.Get(webhook.header())
+		if signature == "" || !webhook.allows(route, topic) {
+			continue
+		}
+		if err := webhook.verify(signature, body, time.Now()); err != nil {
+			signatureErr = fmt.Errorf("webhook %s: %w", webhook.Name, err)
+			continue
+		}
+		return &Identity{Method: AuthMethodWebhook, Subject: webhook.Name}, 0, nil
+	}
+	if signatureErr != nil {
+		return nil, http.StatusUnauthorized, signatureErr
+	}
+
+	if a.Public != nil && a.Public.allows(route, topic) {
+		return nil, 0, nil
+	}
+	return nil, http.StatusUnauthorized, errors.New("authentication required")
+}
+
+// findAPIKey returns the API key with a value, or nil if there is none. All keys
+// are compared in constant time.
+func (a *AuthConfig) findAPIKey(value string) *APIKey {
+	hash := sha256.Sum256([]byte(value))
+	var found *APIKey
+	for _, key := range a.APIKeys {
+		if subtle.ConstantTimeCompare(hash[:], key.hash) == 1 {
+			found = key
+		}
+	}
+	return found
+}
+
+// forward adds an identity to the headers of a message and removes the credentials
+// it was verified with, so that they don't reach backends
+func (a *AuthConfig) forward(headers map[string]interface{}, identity *Identity) {
+	if identity == nil {
+		return
+	}
+	switch identity.Method {
+	case AuthMethodAPIKey:
+		delete(headers, http.CanonicalHeaderKey(a.APIKeyHeader))
+	case AuthMethodJWT:
+		delete(headers, "Authorization")
+	}
+	headers["auth"] = identity
+}
+
+// bearerToken returns the token of a bearer Authorization header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// empty reports whether a scope lists no routes and no topics
+func (s AuthScope) empty() bool {
+	return len(s.Routes) == 0 && len(s.Topics) == 0
+}
+
+// allows reports whether a request to a route and topic is in scope
+func (s AuthScope) allows(route, topic string) bool {
+	if s.empty() {
+		return true
+	}
+	if route != "" && slices.Contains(s.Routes, route) {
+		return true
+	}
+	return matchesAnyPattern(s.Topics, topic)
+}
+
+// matchesAnyPattern reports whether a topic matches one of several patterns
+func matchesAnyPattern(patterns []string, topic string) bool {
+	for _, pattern := range patterns {
+		if matchPattern(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern reports whether s matches a pattern in which * matches any characters
+func matchPattern(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+
+	last := parts[len(parts)-1]
+	if !strings.HasPrefix(s, parts[0]) || len(s) < len(parts[0])+len(last) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, last)
+}
+
+// compile validates the JWT settings and loads the key set
+func (j *JWTConfig) compile() error {
+	if j.Secret == "" && j.JWKSFile == "" {
+		return errors.New("secret or jwks_file must be set")
+	}
+	if j.Leeway < 0 {
+		return errors.New("leeway must not be negative")
+	}
+
+	j.methods = nil
+	if j.Secret != "" {
+		j.methods = append(j.methods, "HS256", "HS384", "HS512")
+	}
+	if j.JWKSFile != "" {
+		keys, err := loadJWKS(j.JWKSFile)
+		if err != nil {
+			return err
+		}
+		j.keys = keys
+		for _, key := range keys {
+			for _, method := range keyMethods(key.key) {
+				if !slices.Contains(j.methods, method) {
+					j.methods = append(j.methods, method)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// verify checks the signature and the registered claims of a token and returns its claims
+func (j *JWTConfig) verify(token string) (jwt.MapClaims, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(j.methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Duration(j.Leeway) * time.Second),
+	}
+	if j.Issuer != "" {
+		options = append(options, jwt.WithIssuer(j.Issuer))
+	}
+	if j.Audience != "" {
+		options = append(options, jwt.WithAudience(j.Audience))
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, j.verificationKey, options...); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verificationKey returns the keys a token may be signed with. Tokens with a key
+// ID are only verified with the key of that ID.
+func (j *JWTConfig) verificationKey(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return []byte(j.Secret), nil
+	}
+
+	id, _ := token.Header["kid"].(string)
+	var keys jwt.VerificationKeySet
+	for _, key := range j.keys {
+		if (id == "" || key.id == id) && (key.algorithm == "" || key.algorithm == token.Method.Alg()) &&
+			slices.Contains(keyMethods(key.key), token.Method.Alg()) {
+			keys.Keys = append(keys.Keys, key.key)
+		}
+	}
+	if len(keys.Keys) == 0 {
+		return nil, fmt.Errorf("no key for %s tokens with key ID %q", token.Method.Alg(), id)
+	}
+	return keys, nil
+}
+
+// allows reports whether the topics claim of a token contains a topic. The claim
+// is a list of topic patterns or a string of patterns separated by spaces.
+func (j *JWTConfig) allows(claims jwt.MapClaims, topic string) bool {
+	if j.TopicsClaim == "" {
+		return true
+	}
+
+	var patterns []string
+	switch value := claims[j.TopicsClaim].(type) {
+	case string:
+		patterns = strings.Fields(value)
+	case []interface{}:
+		for _, item := range value {
+			if pattern, ok := item.(string); ok {
+				patterns = append(patterns, pattern)
+			}
+		}
+	}
+	return matchesAnyPattern(patterns, topic)
+}
+
+// loadJWKS reads the signature keys of a JSON Web Key Set
+func loadJWKS(path string) ([]jsonWebKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS file %s: %w", path, err)
+	}
+
+	var keys []jsonWebKey
+	for i, k := range set.Keys {
+		if k.Use == "enc" {
+			continue
+		}
+		key, err := parseJSONWebKey(k.Kty, k.Crv, k.N, k.E, k.X, k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS file %s: key %d: %w", path, i+1, err)
+		}
+		keys = append(keys, jsonWebKey{id: k.Kid, algorithm: k.Alg, key: key})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("invalid JWKS file %s: no signature keys", path)
+	}
+	return keys, nil
+}
+
+// parseJSONWebKey converts the parameters of an RSA, EC or OKP key to a public key
+func parseJSONWebKey(kty, crv, n, e, x, y string) (interface{}, error) {
+	decode := func(name, value string) ([]byte, error) {
+		data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+		if err != nil || len(data) == 0 {
+			return nil, fmt.Errorf("invalid %s parameter", name)
+		}
+		return data, nil
+	}
+
+	switch kty {
+	case "RSA":
+		modulus, err := decode("n", n)
+		if err != nil {
+			return nil, err
+		}
+		exponent, err := decode("e", e)
+		if err != nil {
+			return nil, err
+		}
+		if len(exponent) > 4 {
+			return nil, errors.New("RSA exponent too large")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(modulus),
+			E: int(new(big.Int).SetBytes(exponent).Int64()),
+		}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		var validator ecdh.Curve
+		switch crv {
+		case "P-256":
+			curve, validator = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, validator = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, validator = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", crv)
+		}
+		xBytes, err := decode("x", x)
+		if err != nil {
+			return nil, err
+		}
+		yBytes, err := decode("y", y)
+		if err != nil {
+			return nil, err
+		}
+
+		// The uncompressed encoding is only accepted for points on the curve
+		size := (curve.Params().BitSize + 7) / 8
+		if len(xBytes) > size || len(yBytes) > size {
+			return nil, errors.New("invalid EC point")
+		}
+		point := make([]byte, 1+2*size)
+		point[0] = 4
+		copy(point[1+size-len(xBytes):], xBytes)
+		copy(point[1+2*size-len(yBytes):], yBytes)
+		if _, err := validator.NewPublicKey(point); err != nil {
+			return nil, errors.New("invalid EC point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(xBytes), Y: new(big.Int).SetBytes(yBytes)}, nil
+
+	case "OKP":
+		if crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", crv)
+		}
+		xBytes, err := decode("x", x)
+		if err != nil {
+			return nil, err
+		}
+		if len(xBytes) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(xBytes), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", kty)
+	}
+}
+
+// keyMethods returns the signing methods a public key can verify
+func keyMethods(key interface{}) []string {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+	case *ecdsa.PublicKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			return []string{"ES256"}
+		case 384:
+			return []string{"ES384"}
+		default:
+			return []string{"ES512"}
+		}
+	case ed25519.PublicKey:
+		return []string{"EdDSA"}
+	default:
+		return nil
+	}
+}
+
+// header returns the name of the header carrying the signature
+func (w *Webhook) header() string {
+	switch {
+	case w.Header != "":
+		return w.Header
+	case w.Scheme == WebhookSchemeStripe:
+		return "Stripe-Signature"
+	default:
+		return "X-Hub-Signature-256"
+	}
+}
+
+// verify checks the signature of a webhook request body
+func (w *Webhook) verify(signature string, body []byte, now time.Time) error {
+	if w.Scheme != WebhookSchemeStripe {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(body)
+		if !equalHexMAC(strings.TrimPrefix(signature, "sha256="), mac.Sum(nil)) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(signature, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return errors.New("malformed signature")
+	}
+
+	tolerance := w.Tolerance
+	if tolerance == 0 {
+		tolerance = defaultWebhookTolerance
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > time.Duration(tolerance)*time.Second || age < -time.Duration(tolerance)*time.Second {
+		return errors.New("signature timestamp outside the tolerance")
+	}
+
+	expected, _ := hex.DecodeString(signCallback(w.Secret, timestamp, body))
+	for _, candidate := range signatures {
+		if equalHexMAC(candidate, expected) {
+			return nil
+		}
+	}
+	return errors.New("invalid signature")
+}
+
+// equalHexMAC compares a hex encoded MAC with the expected one in constant time
+func equalHexMAC(signature string, expected []byte) bool {
+	decoded, err := hex.DecodeString(signature)
+	return err == nil && hmac.Equal(decoded, expected)
+}
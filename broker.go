@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Brokers connecting the proxy to backends
+const (
+	BrokerRedis  = "redis"  // Redis pub/sub, streams or lists, see Transport
+	BrokerMemory = "memory" // In-process broker for development and tests
+	BrokerNATS   = "nats"   // NATS subjects with request/reply inboxes
+)
+
+// replyBufferSize is the number of replies buffered per waiting request, large
+// enough to absorb bursts of stream chunks while the client is written to
+const replyBufferSize = 256
+
+// ErrValueNotFound is returned by GetValue for missing or expired keys
+var ErrValueNotFound = errors.New("value not found")
+
+// Broker delivers requests to backends and routes their replies back to the
+// waiting requests. Handlers only use this interface, so brokers can be swapped.
+type Broker interface {
+	// Publish delivers a message to the backends of a topic. ErrNoSubscribers is
+	// returned if the broker knows that no backend received it.
+	Publish(ctx context.Context, topic string, message []byte) error
+
+	// PublishCancel tells every backend of a topic that a reply is no longer awaited
+	PublishCancel(ctx context.Context, cancelTopic string, cancellation []byte) error
+
+	// ReplyTopic returns the reply topic for a correlation ID without waiting for replies
+	ReplyTopic(correlationID string) string
+
+	// AwaitReply registers a waiter for the replies to a correlation ID. It must be
+	// registered before the request is published and closed once it is done.
+	AwaitReply(correlationID string) *ReplyWaiter
+
+	// SetValue stores a value that expires after the TTL, shared by all proxy instances
+	SetValue(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// GetValue returns a stored value, or ErrValueNotFound
+	GetValue(ctx context.Context, key string) ([]byte, error)
+
+	// Health returns an error if requests can't be delivered or replies received
+	Health(ctx context.Context) error
+
+	// Close stops receiving replies and closes the connections
+	Close() error
+}
+
+// Brokers available with the BROKER setting
+var (
+	_ Broker = (*RedisManager)(nil)
+	_ Broker = (*MemoryBroker)(nil)
+	_ Broker = (*NATSBroker)(nil)
+)
+
+// NewBroker connects to the configured broker
+func NewBroker(config Config) (Broker, error) {
+	switch config.Broker {
+	case BrokerMemory:
+		broker := NewMemoryBroker(config)
+		if config.MemoryBrokerEcho {
+			broker.Subscribe("*", broker.echo)
+		}
+		return broker, nil
+	case BrokerNATS:
+		natsBroker, err := NewNATSBroker(config)
+		if err != nil {
+			return nil, err
+		}
+		return natsBroker, nil
+	default:
+		redisManager, err := NewRedisManager(config)
+		if err != nil {
+			return nil, err
+		}
+		return redisManager, nil
+	}
+}
+
+// ReplyWaiter receives the replies published to the reply topic of a single request
+type ReplyWaiter struct {
+	Topic         string
+	CorrelationID string
+	replies       chan string
+	registry      *replyRegistry
+	interruptions uint64 // Interruptions of the reply subscription when the waiter was registered
+}
+
+// Replies returns the channel on which reply payloads are delivered
+func (rw *ReplyWaiter) Replies() <-chan string {
+	return rw.replies
+}
+
+// Interrupted reports whether the reply subscription was interrupted since the
+// waiter was registered, e.g. by a Redis failover, so replies may have been lost
+func (rw *ReplyWaiter) Interrupted() bool {
+	return rw.registry.interruptions.Load() != rw.interruptions
+}
+
+// Close unregisters the waiter; replies arriving afterwards are dropped
+func (rw *ReplyWaiter) Close() {
+	rw.registry.unregister(rw.CorrelationID)
+}
+
+// replyRegistry routes replies to the waiters of in-flight requests by correlation ID
+type replyRegistry struct {
+	mutex   sync.RWMutex
+	waiters map[string]*ReplyWaiter
+
+	// interruptions counts the times replies may have been lost
+	interruptions atomic.Uint64
+}
+
+// newReplyRegistry creates an empty reply registry
+func newReplyRegistry() *replyRegistry {
+	return &replyRegistry{waiters: make(map[string]*ReplyWaiter)}
+}
+
+// register creates a waiter for the replies to a correlation ID on a reply topic
+func (r *replyRegistry) register(topic, correlationID string) *ReplyWaiter {
+	waiter := &ReplyWaiter{
+		Topic:         topic,
+		CorrelationID: correlationID,
+		replies:       make(chan string, replyBufferSize),
+		registry:      r,
+		interruptions: r.interruptions.Load(),
+	}
+
+	r.mutex.Lock()
+	r.waiters[correlationID] = waiter
+	r.mutex.Unlock()
+
+	return waiter
+}
+
+// unregister removes the waiter for a correlation ID
+func (r *replyRegistry) unregister(correlationID string) {
+	r.mutex.Lock()
+	delete(r.waiters, correlationID)
+	r.mutex.Unlock()
+}
+
+// interrupted records that replies may have been lost, e.g. while reconnecting
+func (r *replyRegistry) interrupted() {
+	r.interruptions.Add(1)
+}
+
+// InFlight returns the number of registered waiters
+func (r *replyRegistry) InFlight() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return len(r.waiters)
+}
+
+// deliver passes a reply to the waiter registered for its correlation ID
+func (r *replyRegistry) deliver(logger zerolog.Logger, topic, correlationID, payload string) {
+	r.mutex.RLock()
+	waiter, ok := r.waiters[correlationID]
+	r.mutex.RUnlock()
+
+	if !ok {
+		logger.Debug().Str("channel", topic).Msg("Dropping reply without waiting request")
+		return
+	}
+
+	select {
+	case waiter.replies <- payload:
+	default:
+		logger.Warn().Str("channel", topic).Msg("Reply buffer full, dropping reply")
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// maxCallbackBackoff caps the delay between callback delivery attempts
+const maxCallbackBackoff = time.Minute
+
+// CallbackDelivery represents a single attempt to deliver a reply to a callback URL
+type CallbackDelivery struct {
+	ID           int64     `json:"id"`
+	RequestID    string    `json:"request_id"`
+	URL          string    `json:"url"`
+	Attempt      int       `json:"attempt"`
+	StatusCode   int       `json:"status_code"`
+	Delivered    bool      `json:"delivered"`
+	ResponseTime int64     `json:"response_time_ms"` // in milliseconds
+	Error        string    `json:"error,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// callbackURL returns the validated callback URL of a request, or an empty string
+// if the request doesn't ask for a callback
+func (ps *ProxyServer) callbackURL(r *http.Request) (string, error) {
+	rawURL := strings.TrimSpace(r.Header.Get(ps.cfg().CallbackHeader))
+	if rawURL == "" {
+		return "", nil
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("invalid callback URL %q", rawURL)
+	}
+
+	if len(ps.cfg().CallbackAllowedHosts) > 0 {
+		allowed := false
+		for _, host := range ps.cfg().CallbackAllowedHosts {
+			if strings.EqualFold(host, parsed.Hostname()) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return "", fmt.Errorf("callback host %q is not allowed", parsed.Hostname())
+		}
+	}
+
+	return parsed.String(), nil
+}
+
+// deliverCallback waits up to timeout seconds for the reply to a request and posts it to the callback URL
+func (ps *ProxyServer) deliverCallback(logger zerolog.Logger, requestID, topic, callbackURL string, timeout int, waiter *ReplyWaiter) {
+	logger = logger.With().Str("callbackURL", callbackURL).Logger()
+
+	timer := time.NewTimer(time.Duration(timeout) * time.Second)
+	defer timer.Stop()
+
+	var contentType string
+	var payload []byte
+	var backendStatus int
+
+	select {
+	case reply := <-waiter.Replies():
+		response, err := parseResponse(reply)
+		if err == nil {
+			contentType, payload, err = encodeResponseBody(response)
+		}
+		if err != nil {
+			logger.Error().Err(err).Str("payload", truncateString(reply, 500)).Msg("Error parsing callback response")
+			backendStatus = http.StatusInternalServerError
+			contentType, payload = callbackErrorPayload(requestID, fmt.Errorf("error parsing response: %w", err))
+		} else {
+			backendStatus = response.Status
+		}
+	case <-timer.C:
+		logger.Error().Int("timeout", timeout).Msg("Callback response timeout")
+		backendStatus = http.StatusGatewayTimeout
+		err := replyTimeoutError(timeout, waiter)
+		ps.metrics.Timeout(topic, timeoutCallback)
+		ps.cancelRequest(logger, topic, requestID, err)
+		contentType, payload = callbackErrorPayload(requestID, err)
+	case <-ps.backgroundCtx.Done():
+		logger.Warn().Msg("Proxy shutting down, callback not delivered")
+		ps.cancelRequest(logger, topic, requestID, fmt.Errorf("proxy shut down before the response arrived"))
+		return
+	}
+
+	backoff := time.Duration(ps.cfg().CallbackRetryBaseMs) * time.Millisecond
+	for attempt := 1; attempt <= ps.cfg().CallbackMaxAttempts; attempt++ {
+		delivery := ps.postCallback(requestID, callbackURL, attempt, contentType, payload, backendStatus)
+
+		if ps.dbLogger != nil && ps.dbLogger.enabled {
+			if err := ps.dbLogger.LogCallbackDelivery(delivery); err != nil {
+				logger.Error().Err(err).Msg("Failed to log callback delivery")
+			}
+		}
+
+		if delivery.Delivered {
+			logger.Debug().Int("attempt", attempt).Int("status", delivery.StatusCode).Msg("Callback delivered")
+			return
+		}
+
+		logger.Warn().Int("attempt", attempt).Int("status", delivery.StatusCode).Str("error", delivery.Error).
+			Msg("Callback delivery failed")
+
+		// Client errors other than rate limiting won't succeed on retry
+		if delivery.StatusCode >= 400 && delivery.StatusCode < 500 && delivery.StatusCode != http.StatusTooManyRequests {
+			break
+		}
+		if attempt == ps.cfg().CallbackMaxAttempts {
+			break
+		}
+
+		// Exponential backoff with up to 20% jitter
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff)/5+1))
+		select {
+		case <-time.After(wait):
+		case <-ps.backgroundCtx.Done():
+			logger.Warn().Msg("Proxy shutting down, callback retries abandoned")
+			return
+		}
+		if backoff < maxCallbackBackoff {
+			backoff *= 2
+		}
+	}
+
+	logger.Error().Msg("Giving up on callback delivery")
+}
+
+// postCallback makes a single delivery attempt
+func (ps *ProxyServer) postCallback(requestID, callbackURL string, attempt int, contentType string, payload []byte, backendStatus int) *CallbackDelivery {
+	delivery := &CallbackDelivery{
+		RequestID: requestID,
+		URL:       callbackURL,
+		Attempt:   attempt,
+		Timestamp: time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(ps.backgroundCtx, ps.callbackClient.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(payload))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Request-ID", requestID)
+	req.Header.Set("X-Callback-Status", strconv.Itoa(backendStatus))
+	req.Header.Set("X-Callback-Attempt", strconv.Itoa(attempt))
+	if ps.cfg().CallbackSecret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Signature-Timestamp", timestamp)
+		req.Header.Set("X-Signature-256", "sha256="+signCallback(ps.cfg().CallbackSecret, timestamp, payload))
+	}
+
+	resp, err := ps.callbackClient.Do(req)
+	delivery.ResponseTime = time.Since(delivery.Timestamp).Milliseconds()
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	defer resp.Body.Close()
+
+	delivery.StatusCode = resp.StatusCode
+	delivery.Delivered = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !delivery.Delivered {
+		delivery.Error = fmt.Sprintf("callback endpoint responded with %d", resp.StatusCode)
+	}
+	return delivery
+}
+
+// signCallback computes the hex encoded HMAC-SHA256 of "<timestamp>.<payload>"
+func signCallback(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// callbackErrorPayload builds the JSON payload sent when no usable reply was received
+func callbackErrorPayload(requestID string, err error) (string, []byte) {
+	payload, _ := json.Marshal(map[string]string{
+		"request_id": requestID,
+		"error":      err.Error(),
+	})
+	return "application/json", payload
+}
+
+// LogCallbackDelivery records a callback delivery attempt
+func (l *DBLogger) LogCallbackDelivery(delivery *CallbackDelivery) error {
+	if !l.enabled {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	_, err := l.db.Exec(`
+		INSERT INTO callback_deliveries
+		(request_id, url, attempt, status_code, delivered, response_time, error, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, delivery.RequestID, delivery.URL, delivery.Attempt, delivery.StatusCode, delivery.Delivered,
+		delivery.ResponseTime, delivery.Error, delivery.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	// Keep the delivery log bounded like the request log
+	_, err = l.db.Exec(`
+		DELETE FROM callback_deliveries
+		WHERE id <= (SELECT id FROM callback_deliveries ORDER BY id DESC LIMIT 1 OFFSET ?)
+	`, l.maxEntries)
+	return err
+}
+
+// GetCallbackDeliveries retrieves the latest callback delivery attempts,
+// optionally only those of a single request
+func (l *DBLogger) GetCallbackDeliveries(limit int, requestID string) ([]CallbackDelivery, error) {
+	if !l.enabled {
+		return nil, nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if limit <= 0 || limit > l.maxEntries {
+		limit = l.maxEntries
+	}
+
+	query := `
+		SELECT id, request_id, url, attempt, status_code, delivered, response_time, error, timestamp
+		FROM callback_deliveries `
+	var args []interface{}
+	if requestID != "" {
+		query += "WHERE request_id = ? "
+		args = append(args, requestID)
+	}
+	query += "ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := l.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []CallbackDelivery
+	for rows.Next() {
+		var delivery CallbackDelivery
+		err := rows.Scan(
+			&delivery.ID, &delivery.RequestID, &delivery.URL, &delivery.Attempt, &delivery.StatusCode,
+			&delivery.Delivered, &delivery.ResponseTime, &delivery.Error, &delivery.Timestamp,
+		)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+const callbacksHTMLTemplate = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Redis Proxy Callbacks</title>
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            margin: 0;
+            padding: 0;
+            background-color: #f5f5f5;
+        }
+        .container {
+            max-width: 1200px;
+            margin: 0 auto;
+            padding: 20px;
+        }
+        header {
+            background-color: #333;
+            color: white;
+            padding: 15px 0;
+            text-align: center;
+        }
+        h1 {
+            margin: 0;
+        }
+        nav {
+            background-color: #444;
+            padding: 10px 0;
+            text-align: center;
+        }
+        nav a {
+            color: white;
+            text-decoration: none;
+            margin: 0 15px;
+            padding: 5px 10px;
+            border-radius: 3px;
+            transition: background-color 0.3s;
+        }
+        nav a:hover {
+            background-color: #555;
+        }
+        .controls {
+            margin-bottom: 20px;
+            display: flex;
+            align-items: center;
+            gap: 10px;
+            background-color: white;
+            padding: 15px;
+            border-radius: 5px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+        }
+        table {
+            width: 100%;
+            border-collapse: collapse;
+            background-color: white;
+            border-radius: 5px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+        }
+        th, td {
+            text-align: left;
+            padding: 10px;
+            border-bottom: 1px solid #eee;
+            font-size: 0.9em;
+        }
+        th {
+            background-color: #fafafa;
+        }
+        .url {
+            word-break: break-all;
+        }
+        .status-code {
+            padding: 3px 8px;
+            border-radius: 3px;
+            color: white;
+        }
+        .status-2xx {
+            background-color: #4caf50;
+        }
+        .status-4xx {
+            background-color: #ff9800;
+        }
+        .status-5xx {
+            background-color: #f44336;
+        }
+        .status-0 {
+            background-color: #9e9e9e;
+        }
+        .empty-message {
+            text-align: center;
+            padding: 40px;
+            color: #666;
+        }
+        .loading {
+            text-align: center;
+            padding: 20px;
+            color: #666;
+        }
+        button {
+            padding: 8px 15px;
+            background-color: #4285f4;
+            color: white;
+            border: none;
+            border-radius: 4px;
+            cursor: pointer;
+        }
+        button:hover {
+            background-color: #3367d6;
+        }
+        input, select {
+            padding: 8px;
+            border: 1px solid #ddd;
+            border-radius: 4px;
+        }
+        .error {
+            color: #f44336;
+        }
+        .footer {
+            text-align: center;
+            padding: 20px;
+            color: #666;
+            font-size: 0.8em;
+        }
+    </style>
+</head>
+<body>
+    <header>
+        <h1>Redis Proxy Callbacks</h1>
+    </header>
+
+    <nav>
+        <a href="/dashboard">Home</a>
+        <a href="/dashboard/stats">Statistics</a>
+        <a href="/dashboard/logs">Logs</a>
+        <a href="/dashboard/callbacks">Callbacks</a>
+    </nav>
+
+    <div class="container">
+        <div class="controls">
+            <button id="refresh-btn">Refresh</button>
+
+            <input type="text" id="request-id" placeholder="Filter by request ID" size="40">
+
+            <div style="margin-left: auto;">
+                <label for="limit">Show:</label>
+                <select id="limit" name="limit">
+                    <option value="25">25 attempts</option>
+                    <option value="100" selected>100 attempts</option>
+                    <option value="500">500 attempts</option>
+                </select>
+            </div>
+        </div>
+
+        <div id="callbacks-container">
+            <div class="loading">Loading callback deliveries...</div>
+        </div>
+    </div>
+
+    <div class="footer">
+        Redis HTTP Proxy Callbacks - Version 1.0
+    </div>
+
+    <script>
+        // Function to get status code class
+        function getStatusCodeClass(code) {
+            if (!code) return 'status-0';
+            if (code >= 200 && code < 300) return 'status-2xx';
+            if (code >= 400 && code < 500) return 'status-4xx';
+            if (code >= 500) return 'status-5xx';
+            return 'status-0';
+        }
+
+        // Function to add a text cell to a table row
+        function addCell(row, text, className) {
+            const cell = document.createElement('td');
+            cell.textContent = text;
+            if (className) cell.className = className;
+            row.appendChild(cell);
+            return cell;
+        }
+
+        // Function to fetch and display callback deliveries
+        function fetchCallbacks() {
+            const limit = document.getElementById('limit').value;
+            const requestID = document.getElementById('request-id').value.trim();
+            const container = document.getElementById('callbacks-container');
+
+            let url = '/dashboard/api/callbacks?limit=' + limit;
+            if (requestID) {
+                url += '&request_id=' + encodeURIComponent(requestID);
+            }
+
+            fetch(url)
+                .then(response => {
+                    if (!response.ok) {
+                        throw new Error('Failed to fetch callback deliveries');
+                    }
+                    return response.json();
+                })
+                .then(deliveries => {
+                    if (!deliveries || deliveries.length === 0) {
+                        container.innerHTML = '<div class="empty-message">No callback deliveries found</div>';
+                        return;
+                    }
+
+                    const table = document.createElement('table');
+                    const header = document.createElement('tr');
+                    ['Time', 'Request ID', 'URL', 'Attempt', 'Status', 'Duration', 'Error'].forEach(title => {
+                        const th = document.createElement('th');
+                        th.textContent = title;
+                        header.appendChild(th);
+                    });
+                    table.appendChild(header);
+
+                    deliveries.forEach(delivery => {
+                        const row = document.createElement('tr');
+                        addCell(row, new Date(delivery.timestamp).toLocaleString());
+                        addCell(row, delivery.request_id);
+                        addCell(row, delivery.url, 'url');
+                        addCell(row, delivery.attempt);
+
+                        const statusCell = addCell(row, '');
+                        const status = document.createElement('span');
+                        status.className = 'status-code ' + (delivery.delivered ? 'status-2xx' : getStatusCodeClass(delivery.status_code || 500));
+                        status.textContent = delivery.status_code || 'failed';
+                        statusCell.appendChild(status);
+
+                        addCell(row, (delivery.response_time_ms || 0) + 'ms');
+                        addCell(row, delivery.error || '', 'error');
+                        table.appendChild(row);
+                    });
+
+                    container.innerHTML = '';
+                    container.appendChild(table);
+                })
+                .catch(error => {
+                    container.innerHTML = '<div class="error">Error: ' + error.message + '</div>';
+                });
+        }
+
+        // Set up event listeners
+        document.addEventListener('DOMContentLoaded', function() {
+            fetchCallbacks();
+            document.getElementById('refresh-btn').addEventListener('click', fetchCallbacks);
+            document.getElementById('limit').addEventListener('change', fetchCallbacks);
+            document.getElementById('request-id').addEventListener('change', fetchCallbacks);
+        });
+    </script>
+</body>
+</html>
+`
+
+func renderCallbacksTemplate(w http.ResponseWriter) {
+	// Set content type
+	w.Header().Set("Content-Type", "text/html")
+
+	// Parse and execute template
+	tmpl, err := template.New("callbacks").Parse(callbacksHTMLTemplate)
+	if err != nil {
+		log.Error().Err(err).Msg("Error parsing callbacks template")
+		http.Error(w, "Error generating callbacks page", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tmpl.Execute(w, nil); err != nil {
+		log.Error().Err(err).Msg("Error executing callbacks template")
+	}
+}
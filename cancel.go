@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// StatusClientClosedRequest is the non-standard status logged for requests whose
+// client went away before the reply arrived
+const StatusClientClosedRequest = 499
+
+// errClientClosedRequest is logged for requests whose client went away
+var errClientClosedRequest = errors.New("client closed request")
+
+// Cancellation is published to the cancel topic of a request's topic when the
+// proxy stops waiting for its reply
+type Cancellation struct {
+	RequestID string `json:"request_id"`
+	Reason    string `json:"reason"`
+}
+
+// replyTimeoutError describes a request whose reply didn't arrive in time, noting
+// when the reply may have been lost while the reply subscription was down
+func replyTimeoutError(timeout int, waiter *ReplyWaiter) error {
+	if waiter.Interrupted() {
+		return fmt.Errorf("response timeout after %d seconds, the reply may have been lost while the Redis connection was interrupted", timeout)
+	}
+	return fmt.Errorf("response timeout after %d seconds", timeout)
+}
+
+// cancelRequest tells backends that the reply to a request is no longer awaited
+func (ps *ProxyServer) cancelRequest(logger zerolog.Logger, topic, requestID string, reason error) {
+	if ps.cfg().CancelTopicSuffix == "" {
+		return
+	}
+
+	cancellation, err := json.Marshal(Cancellation{RequestID: requestID, Reason: reason.Error()})
+	if err != nil {
+		logger.Error().Err(err).Msg("Error creating cancellation")
+		return
+	}
+
+	// The request context may already be done, cancellation must still go out
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cancelTopic := topic + ps.cfg().CancelTopicSuffix
+	if err := ps.broker.PublishCancel(ctx, cancelTopic, cancellation); err != nil {
+		logger.Error().Err(err).Str("cancelTopic", cancelTopic).Msg("Error publishing cancellation")
+		return
+	}
+	logger.Debug().Str("cancelTopic", cancelTopic).Str("reason", reason.Error()).Msg("Cancellation published")
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// settingsSource holds the settings of a config file while a configuration is
+// loaded, keyed by the name of the corresponding environment variable
+type settingsSource struct {
+	values    map[string]string
+	requested map[string]bool
+	errs      []error
+}
+
+var (
+	// loadMutex serializes configuration loads, which share fileSettings
+	loadMutex sync.Mutex
+
+	// fileSettings is only set while a configuration is loaded
+	fileSettings *settingsSource
+)
+
+// lookup returns the file value of a setting and records that it is known
+func (s *settingsSource) lookup(key string) (string, bool) {
+	s.requested[key] = true
+	value, ok := s.values[key]
+	return value, ok
+}
+
+// invalidSetting records a setting that could not be parsed while a configuration is loaded
+func invalidSetting(key, value, expected string) {
+	if fileSettings != nil {
+		fileSettings.errs = append(fileSettings.errs, fmt.Errorf("%s: invalid value %q, expected %s", key, value, expected))
+	}
+}
+
+// LoadConfig loads the proxy and dashboard configuration from environment variables
+// and, if a path is given, a YAML or JSON config file. Environment variables take
+// precedence over the file. All problems are reported together in the returned error.
+func LoadConfig(path string) (Config, DashboardConfig, error) {
+	loadMutex.Lock()
+	defer loadMutex.Unlock()
+
+	source := &settingsSource{values: map[string]string{}, requested: map[string]bool{}}
+	var inlineRoutes interface{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, DashboardConfig{}, fmt.Errorf("failed to read config file: %w", err)
+		}
+
+		// YAML is a superset of JSON, so both are parsed the same way
+		var document map[string]interface{}
+		if err := yaml.Unmarshal(data, &document); err != nil {
+			return Config{}, DashboardConfig{}, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+
+		// A list of routes is the routing table, anything else is a setting
+		if routes, ok := document["routes"].([]interface{}); ok {
+			inlineRoutes = routes
+			delete(document, "routes")
+		}
+		if err := flattenSettings("", document, source.values); err != nil {
+			return Config{}, DashboardConfig{}, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	}
+
+	fileSettings = source
+	config := LoadConfigFromEnv()
+	dashboardConfig := LoadDashboardConfigFromEnv()
+	fileSettings = nil
+
+	errs := source.errs
+	var unknown []string
+	for key := range source.values {
+		if !source.requested[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		errs = append(errs, fmt.Errorf("unknown setting %s", strings.ToLower(key)))
+	}
+	errs = append(errs, validateConfig(config, dashboardConfig)...)
+	if config.Broker == BrokerRedis {
+		if _, err := redisOptions(config); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Load the routing table from the config file or the routes file
+	switch {
+	case inlineRoutes != nil && config.RoutesFile != "":
+		errs = append(errs, errors.New("routes and routes_file cannot both be set"))
+	case inlineRoutes != nil:
+		data, err := json.Marshal(map[string]interface{}{"routes": inlineRoutes})
+		if err == nil {
+			config.Routes, err = parseRouteTable(data)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("routes: %w", err))
+		}
+	case config.RoutesFile != "":
+		routes, err := LoadRouteTable(config.RoutesFile)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		config.Routes = routes
+	}
+
+	// Load the authentication settings, which may refer to routes by name
+	if config.AuthFile != "" {
+		auth, err := LoadAuthConfig(config.AuthFile)
+		if err == nil {
+			err = auth.checkRoutes(config.Routes)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+		config.Auth = auth
+	}
+
+	if len(errs) > 0 {
+		if path != "" {
+			return config, dashboardConfig, fmt.Errorf("invalid configuration (%s):\n%w", path, errors.Join(errs...))
+		}
+		return config, dashboardConfig, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return config, dashboardConfig, nil
+}
+
+// flattenSettings converts nested config file sections to environment variable
+// names, e.g. redis: {pool_size: 10} becomes REDIS_POOL_SIZE=10
+func flattenSettings(prefix string, section map[string]interface{}, values map[string]string) error {
+	for key, value := range section {
+		name := strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if err := flattenSettings(name, v, values); err != nil {
+				return err
+			}
+			continue
+		case []interface{}:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				s, err := settingValue(item)
+				if err != nil {
+					return fmt.Errorf("%s: %w", strings.ToLower(name), err)
+				}
+				items = append(items, s)
+			}
+			values[name] = strings.Join(items, ",")
+		case nil:
+			continue
+		default:
+			s, err := settingValue(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", strings.ToLower(name), err)
+			}
+			values[name] = s
+		}
+	}
+	return nil
+}
+
+// settingValue formats a scalar config file value like an environment variable
+func settingValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// validateConfig checks settings that can't be used as they are
+func validateConfig(config Config, dashboardConfig DashboardConfig) []error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(config.Broker == BrokerRedis || config.Broker == BrokerMemory || config.Broker == BrokerNATS,
+		"BROKER: unknown broker %q, expected %s, %s or %s", config.Broker, BrokerRedis, BrokerMemory, BrokerNATS)
+	check(config.Transport == TransportPubSub || config.Transport == TransportStreams || config.Transport == TransportQueue,
+		"TRANSPORT: unknown transport %q, expected %s, %s or %s", config.Transport, TransportPubSub, TransportStreams, TransportQueue)
+	check(config.TracingExporter == TracingExporterNone || config.TracingExporter == TracingExporterOTLP ||
+		config.TracingExporter == TracingExporterStdout,
+		"TRACING_EXPORTER: unknown exporter %q, expected %s or %s", config.TracingExporter, TracingExporterOTLP, TracingExporterStdout)
+	check(config.Port > 0 && config.Port < 65536, "PORT: invalid port %d", config.Port)
+	check(dashboardConfig.Port > 0 && dashboardConfig.Port < 65536, "DASHBOARD_PORT: invalid port %d", dashboardConfig.Port)
+	check(config.RespondImmediatelyStatus == 0 || (config.RespondImmediatelyStatus >= 100 && config.RespondImmediatelyStatus <= 599),
+		"RESPOND_IMMEDIATELY_STATUS_CODE: invalid status code %d", config.RespondImmediatelyStatus)
+	check(config.ResponseTimeout > 0, "RESPONSE_TIMEOUT: must be positive")
+	check(config.JobTimeout > 0, "JOB_TIMEOUT: must be positive")
+	check(config.CallbackTimeout > 0, "CALLBACK_TIMEOUT: must be positive")
+	check(config.StreamingIdleTimeout > 0, "STREAMING_IDLE_TIMEOUT: must be positive")
+	check(config.StreamingMaxDuration > 0, "STREAMING_MAX_DURATION: must be positive")
+	check(config.DBMaxEntries >= 0, "DB_MAX_ENTRIES: must not be negative")
+	check(config.ConfigReloadInterval >= 0, "CONFIG_RELOAD_INTERVAL: must not be negative")
+	check(config.MetricsMaxTopics >= 0, "METRICS_MAX_TOPICS: must not be negative")
+	return errs
+}
+
+// keepSetting restores a setting that can't change at runtime and records its name if it changed
+func keepSetting[T comparable](changed *[]string, name string, current T, next *T) {
+	if *next != current {
+		*changed = append(*changed, name)
+		*next = current
+	}
+}
+
+// keepStaticSettings keeps the settings that are bound when the proxy starts and
+// returns the names of those that differ in the new configuration
+func keepStaticSettings(current *Config, next *Config) []string {
+	var changed []string
+	keepSetting(&changed, "REDIS_ADDR", current.RedisAddr, &next.RedisAddr)
+	keepSetting(&changed, "REDIS_PASSWORD", current.RedisPassword, &next.RedisPassword)
+	keepSetting(&changed, "REDIS_DB", current.RedisDB, &next.RedisDB)
+	keepSetting(&changed, "REDIS_POOL_SIZE", current.RedisPoolSize, &next.RedisPoolSize)
+	keepSetting(&changed, "REDIS_MODE", current.RedisMode, &next.RedisMode)
+	keepSetting(&changed, "REDIS_URL", current.RedisURL, &next.RedisURL)
+	keepSetting(&changed, "REDIS_USERNAME", current.RedisUsername, &next.RedisUsername)
+	keepSetting(&changed, "REDIS_SENTINEL_MASTER", current.RedisSentinelMaster, &next.RedisSentinelMaster)
+	keepSetting(&changed, "REDIS_SENTINEL_USERNAME", current.RedisSentinelUsername, &next.RedisSentinelUsername)
+	keepSetting(&changed, "REDIS_SENTINEL_PASSWORD", current.RedisSentinelPassword, &next.RedisSentinelPassword)
+	keepSetting(&changed, "REDIS_SHARDED_PUBSUB", current.RedisShardedPubSub, &next.RedisShardedPubSub)
+	keepSetting(&changed, "REDIS_TLS", current.RedisTLS, &next.RedisTLS)
+	keepSetting(&changed, "REDIS_TLS_CA_FILE", current.RedisTLSCAFile, &next.RedisTLSCAFile)
+	keepSetting(&changed, "REDIS_TLS_CERT_FILE", current.RedisTLSCertFile, &next.RedisTLSCertFile)
+	keepSetting(&changed, "REDIS_TLS_KEY_FILE", current.RedisTLSKeyFile, &next.RedisTLSKeyFile)
+	keepSetting(&changed, "REDIS_TLS_SERVER_NAME", current.RedisTLSServerName, &next.RedisTLSServerName)
+	keepSetting(&changed, "REDIS_TLS_INSECURE_SKIP_VERIFY", current.RedisTLSInsecure, &next.RedisTLSInsecure)
+	keepSetting(&changed, "PORT", current.Port, &next.Port)
+	keepSetting(&changed, "HTTP_READ_TIMEOUT", current.ReadTimeout, &next.ReadTimeout)
+	keepSetting(&changed, "HTTP_WRITE_TIMEOUT", current.WriteTimeout, &next.WriteTimeout)
+	keepSetting(&changed, "HTTP_IDLE_TIMEOUT", current.IdleTimeout, &next.IdleTimeout)
+	keepSetting(&changed, "HTTP_MAX_HEADER_BYTES", current.MaxHeaderBytes, &next.MaxHeaderBytes)
+	keepSetting(&changed, "BROKER", current.Broker, &next.Broker)
+	keepSetting(&changed, "MEMORY_BROKER_ECHO", current.MemoryBrokerEcho, &next.MemoryBrokerEcho)
+	keepSetting(&changed, "HEALTH_PATH", current.HealthPath, &next.HealthPath)
+	keepSetting(&changed, "METRICS_PATH", current.MetricsPath, &next.MetricsPath)
+	keepSetting(&changed, "METRICS_MAX_TOPICS", current.MetricsMaxTopics, &next.MetricsMaxTopics)
+	keepSetting(&changed, "TRACING_EXPORTER", current.TracingExporter, &next.TracingExporter)
+	keepSetting(&changed, "TRACING_ENDPOINT", current.TracingEndpoint, &next.TracingEndpoint)
+	keepSetting(&changed, "TRACING_SERVICE_NAME", current.TracingServiceName, &next.TracingServiceName)
+	keepSetting(&changed, "NATS_URL", current.NATSURL, &next.NATSURL)
+	keepSetting(&changed, "NATS_CREDS_FILE", current.NATSCredsFile, &next.NATSCredsFile)
+	keepSetting(&changed, "NATS_JOB_BUCKET", current.NATSJobBucket, &next.NATSJobBucket)
+	keepSetting(&changed, "TRANSPORT", current.Transport, &next.Transport)
+	keepSetting(&changed, "STREAM_MAXLEN", current.StreamMaxLen, &next.StreamMaxLen)
+	keepSetting(&changed, "JOBS_PATH", current.JobsPath, &next.JobsPath)
+	keepSetting(&changed, "JOB_TTL", current.JobTTL, &next.JobTTL)
+	keepSetting(&changed, "JOB_KEY_PREFIX", current.JobKeyPrefix, &next.JobKeyPrefix)
+	keepSetting(&changed, "CALLBACK_REQUEST_TIMEOUT", current.CallbackRequestTimeout, &next.CallbackRequestTimeout)
+	keepSetting(&changed, "WEBSOCKET_PATH", current.WebSocketPath, &next.WebSocketPath)
+	keepSetting(&changed, "PROXY_INSTANCE_ID", current.InstanceID, &next.InstanceID)
+	keepSetting(&changed, "REPLY_PREFIX", current.ReplyPrefix, &next.ReplyPrefix)
+	keepSetting(&changed, "REPLY_SHARDS", current.ReplyShards, &next.ReplyShards)
+	keepSetting(&changed, "DB_LOG_PATH", current.DBLogPath, &next.DBLogPath)
+	keepSetting(&changed, "DB_MAX_ENTRIES", current.DBMaxEntries, &next.DBMaxEntries)
+	if !slices.Equal(current.WebSocketOrigins, next.WebSocketOrigins) {
+		changed = append(changed, "WEBSOCKET_ALLOWED_ORIGINS")
+		next.WebSocketOrigins = current.WebSocketOrigins
+	}
+	return changed
+}
+
+// Reload applies a new configuration, including the routing table, in a single
+// atomic swap. Requests in flight finish with the timeouts they started with.
+func (ps *ProxyServer) Reload(config Config) {
+	if changed := keepStaticSettings(ps.cfg(), &config); len(changed) > 0 {
+		log.Warn().Strs("settings", changed).Msg("Changed settings only take effect after a restart")
+	}
+	ps.config.Store(&config)
+}
+
+// fileState identifies a version of a watched file
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// configReloader reloads the configuration on SIGHUP and when the config file,
+// the routes file or the auth files change
+type configReloader struct {
+	path     string
+	interval time.Duration
+	files    map[string]fileState
+}
+
+// newConfigReloader creates a reloader for the config file at path, which may be
+// empty if only environment variables and a routes file are used
+func newConfigReloader(path string, config Config) *configReloader {
+	cr := &configReloader{
+		path:     path,
+		interval: time.Duration(config.ConfigReloadInterval) * time.Second,
+	}
+	cr.watch(config)
+	return cr
+}
+
+// watch records the current state of the files of a configuration
+func (cr *configReloader) watch(config Config) {
+	cr.files = make(map[string]fileState)
+	files := []string{cr.path, config.RoutesFile, config.AuthFile}
+	if config.Auth != nil {
+		files = append(files, config.Auth.files()...)
+	}
+	for _, file := range files {
+		if file != "" {
+			cr.files[file] = statFile(file)
+		}
+	}
+}
+
+// statFile returns the state of a file, or the zero state if it can't be read
+func statFile(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{modTime: info.ModTime(), size: info.Size()}
+}
+
+// changed reports whether any watched file has changed
+func (cr *configReloader) changed() bool {
+	for file, state := range cr.files {
+		if statFile(file) != state {
+			return true
+		}
+	}
+	return false
+}
+
+// Run reloads the configuration until the context is done. The proxy is nil in
+// dashboard-only mode, where only the log level is reloaded.
+func (cr *configReloader) Run(ctx context.Context, proxy *ProxyServer) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	var poll <-chan time.Time
+	if cr.interval > 0 && len(cr.files) > 0 {
+		ticker := time.NewTicker(cr.interval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		select {
+		case <-hangup:
+			log.Info().Msg("SIGHUP received, reloading configuration")
+			cr.reload(proxy)
+		case <-poll:
+			if cr.changed() {
+				log.Info().Msg("Configuration file changed, reloading configuration")
+				cr.reload(proxy)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reload loads and applies the configuration, keeping the current one if the new one is invalid
+func (cr *configReloader) reload(proxy *ProxyServer) {
+	config, dashboardConfig, err := LoadConfig(cr.path)
+	// Don't retry an invalid file until it changes again
+	cr.watch(config)
+	if err != nil {
+		log.Error().Err(err).Msg("Configuration reload failed, keeping the current configuration")
+		return
+	}
+
+	if proxy != nil {
+		proxy.Reload(config)
+	}
+	zerolog.SetGlobalLevel(min(config.LogLevel, dashboardConfig.LogLevel))
+
+	routes := 0
+	if config.Routes != nil {
+		routes = len(config.Routes.Routes)
+	}
+	log.Info().Int("routes", routes).Str("logLevel", zerolog.GlobalLevel().String()).Msg("Configuration reloaded")
+}
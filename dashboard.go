@@ -15,8 +15,11 @@ import (
 type DashboardServer struct {
 	config   DashboardConfig
 	dbLogger *DBLogger
+	metrics  *dashboardMetrics
 	server   *http.Server
 	wg       sync.WaitGroup
+	// Closed on shutdown to end the log streams, which would otherwise keep it waiting
+	streamsDone chan struct{}
 }
 
 // DashboardConfig holds configuration for the dashboard server
@@ -28,6 +31,7 @@ type DashboardConfig struct {
 	IdleTimeout     time.Duration
 	MaxHeaderBytes  int
 	ShutdownTimeout int
+	MetricsPath     string // Path of the Prometheus metrics endpoint, empty disables it
 	Debug           bool
 	LogLevel        zerolog.Level
 }
@@ -43,7 +47,8 @@ func LoadDashboardConfigFromEnv() DashboardConfig {
 		IdleTimeout:     time.Duration(getEnvAsInt("DASHBOARD_IDLE_TIMEOUT", 60)) * time.Second,
 		MaxHeaderBytes:  getEnvAsInt("DASHBOARD_MAX_HEADER_BYTES", 1<<20), // 1MB
 		ShutdownTimeout: getEnvAsInt("DASHBOARD_SHUTDOWN_TIMEOUT", 30),
-		LogLevel:        getLogLevel(getEnv("DASHBOARD_LOG_LEVEL", "info")),
+		MetricsPath:     getEnv("DASHBOARD_METRICS_PATH", "/metrics"),
+		LogLevel:        getEnvAsLogLevel("DASHBOARD_LOG_LEVEL", "info"),
 	}
 
 	// Support DEBUG environment variable
@@ -58,19 +63,32 @@ func LoadDashboardConfigFromEnv() DashboardConfig {
 // NewDashboardServer creates a new dashboard server
 func NewDashboardServer(config DashboardConfig, dbLogger *DBLogger) *DashboardServer {
 	dashboard := &DashboardServer{
-		config:   config,
-		dbLogger: dbLogger,
+		config:      config,
+		dbLogger:    dbLogger,
+		metrics:     newDashboardMetrics(),
+		streamsDone: make(chan struct{}),
 	}
 
 	// Create HTTP server with proper timeouts
 	mux := http.NewServeMux()
 
-	// Register dashboard routes
-	mux.HandleFunc("/dashboard", dashboard.handleDashboard)
-	mux.HandleFunc("/dashboard/logs", dashboard.handleLogs)
-	mux.HandleFunc("/dashboard/stats", dashboard.handleStats)
-	mux.HandleFunc("/dashboard/api/logs", dashboard.handleLogsAPI)
-	mux.HandleFunc("/dashboard/api/stats", dashboard.handleStatsAPI)
+	// Register dashboard routes, counted in the dashboard metrics
+	handle := func(pattern string, handler http.HandlerFunc) {
+		mux.Handle(pattern, dashboard.metrics.instrument(pattern, handler))
+	}
+	handle("/dashboard", dashboard.handleDashboard)
+	handle("/dashboard/logs", dashboard.handleLogs)
+	handle("/dashboard/stats", dashboard.handleStats)
+	handle("/dashboard/api/logs", dashboard.handleLogsAPI)
+	handle("/dashboard/api/logs/stream", dashboard.handleLogsStreamAPI)
+	handle("/dashboard/api/stats", dashboard.handleStatsAPI)
+	handle("/dashboard/callbacks", dashboard.handleCallbacks)
+	handle("/dashboard/api/callbacks", dashboard.handleCallbacksAPI)
+
+	// Prometheus metrics
+	if config.MetricsPath != "" {
+		mux.Handle(config.MetricsPath, dashboard.metrics.Handler())
+	}
 
 	dashboard.server = &http.Server{
 		Addr:           fmt.Sprintf(":%d", config.Port),
@@ -80,6 +98,7 @@ func NewDashboardServer(config DashboardConfig, dbLogger *DBLogger) *DashboardSe
 		IdleTimeout:    config.IdleTimeout,
 		MaxHeaderBytes: config.MaxHeaderBytes,
 	}
+	dashboard.server.RegisterOnShutdown(func() { close(dashboard.streamsDone) })
 
 	return dashboard
 }
@@ -118,6 +137,15 @@ func (ds *DashboardServer) handleStats(w http.ResponseWriter, r *http.Request) {
 	renderStatsTemplate(w)
 }
 
+// handleCallbacks handles requests to view callback deliveries in HTML format
+func (ds *DashboardServer) handleCallbacks(w http.ResponseWriter, r *http.Request) {
+	if ds.dbLogger == nil || !ds.dbLogger.enabled {
+		http.Error(w, "Logging not enabled", http.StatusNotFound)
+		return
+	}
+	renderCallbacksTemplate(w)
+}
+
 // handleLogsAPI handles API requests for log data
 func (ds *DashboardServer) handleLogsAPI(w http.ResponseWriter, r *http.Request) {
 	if ds.dbLogger == nil || !ds.dbLogger.enabled {
@@ -128,6 +156,16 @@ func (ds *DashboardServer) handleLogsAPI(w http.ResponseWriter, r *http.Request)
 	handleLogsAPIRequest(w, r, ds.dbLogger)
 }
 
+// handleLogsStreamAPI streams new log entries as Server-Sent Events
+func (ds *DashboardServer) handleLogsStreamAPI(w http.ResponseWriter, r *http.Request) {
+	if ds.dbLogger == nil || !ds.dbLogger.enabled {
+		http.Error(w, "Logging not enabled", http.StatusNotFound)
+		return
+	}
+
+	handleLogsStreamRequest(w, r, ds.dbLogger, ds.streamsDone)
+}
+
 // handleStatsAPI provides statistics about logged requests
 func (ds *DashboardServer) handleStatsAPI(w http.ResponseWriter, r *http.Request) {
 	if ds.dbLogger == nil || !ds.dbLogger.enabled {
@@ -137,3 +175,13 @@ func (ds *DashboardServer) handleStatsAPI(w http.ResponseWriter, r *http.Request
 
 	handleStatsAPIRequest(w, r, ds.dbLogger)
 }
+
+// handleCallbacksAPI handles API requests for callback delivery data
+func (ds *DashboardServer) handleCallbacksAPI(w http.ResponseWriter, r *http.Request) {
+	if ds.dbLogger == nil || !ds.dbLogger.enabled {
+		http.Error(w, "Logging not enabled", http.StatusNotFound)
+		return
+	}
+
+	handleCallbacksAPIRequest(w, r, ds.dbLogger)
+}
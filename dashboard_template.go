@@ -104,6 +104,7 @@ const dashboardHTMLTemplate = `
         <a href="/dashboard">Home</a>
         <a href="/dashboard/stats">Statistics</a>
         <a href="/dashboard/logs">Logs</a>
+        <a href="/dashboard/callbacks">Callbacks</a>
     </nav>
     
     <div class="container">
@@ -144,6 +145,7 @@ const dashboardHTMLTemplate = `
             <ul>
                 <li><strong>Statistics:</strong> View detailed metrics, request rates, response times, and more.</li>
                 <li><strong>Logs:</strong> Browse detailed request and response logs.</li>
+                <li><strong>Callbacks:</strong> Inspect webhook callback delivery attempts.</li>
             </ul>
         </div>
     </div>
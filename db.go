@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -26,6 +27,7 @@ type RequestLogEntry struct {
 	Timestamp     time.Time `json:"timestamp"`
 	ResponseTopic string    `json:"response_topic"`
 	Error         string    `json:"error,omitempty"`
+	Seq           int64     `json:"seq"` // Change sequence number, see initChangeSequence
 }
 
 // DBLogger handles logging of requests and responses to SQLite
@@ -34,9 +36,15 @@ type DBLogger struct {
 	maxEntries  int
 	initialized bool
 	enabled     bool
+	ftsEnabled  bool // Whether searches use the FTS5 index
 	mutex       sync.Mutex
 	queue       chan *RequestLogEntry
+	dropped     atomic.Int64 // Entries dropped because the queue was full
 	wg          sync.WaitGroup
+
+	// Closed and replaced whenever an entry was written, see changes
+	changedMutex sync.Mutex
+	changed      chan struct{}
 }
 
 // NewDBLogger creates a new database logger
@@ -58,6 +66,7 @@ func NewDBLogger(dbPath string, maxEntries int) (*DBLogger, error) {
 		maxEntries: maxEntries,
 		enabled:    true,
 		queue:      make(chan *RequestLogEntry, 100), // Buffer size for queued log entries
+		changed:    make(chan struct{}),
 	}
 
 	// Initialize the database schema
@@ -114,6 +123,56 @@ func (l *DBLogger) initDB() error {
 		return err
 	}
 
+	// Create callback delivery table, one row per delivery attempt
+	_, err = l.db.Exec(`
+		CREATE TABLE IF NOT EXISTS callback_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			request_id TEXT NOT NULL,
+			url TEXT NOT NULL,
+			attempt INTEGER NOT NULL,
+			status_code INTEGER,
+			delivered BOOLEAN NOT NULL DEFAULT 0,
+			response_time INTEGER,
+			error TEXT,
+			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.db.Exec(`CREATE INDEX IF NOT EXISTS idx_callback_request_id ON callback_deliveries(request_id)`)
+	if err != nil {
+		return err
+	}
+
+	// Create WebSocket session table, one row per client connection
+	_, err = l.db.Exec(`
+		CREATE TABLE IF NOT EXISTS websocket_sessions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			session_id TEXT NOT NULL,
+			path TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			remote_addr TEXT,
+			connected_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			disconnected_at DATETIME,
+			messages_in INTEGER NOT NULL DEFAULT 0,
+			messages_out INTEGER NOT NULL DEFAULT 0,
+			error TEXT
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	if err := l.initFullTextSearch(); err != nil {
+		return err
+	}
+
+	if err := l.initChangeSequence(); err != nil {
+		return err
+	}
+
 	l.initialized = true
 	return nil
 }
@@ -134,6 +193,8 @@ func (l *DBLogger) startWorker() {
 			// Insert the log entry
 			if err := l.insertLogEntry(entry); err != nil {
 				log.Error().Err(err).Str("requestID", entry.RequestID).Msg("Failed to insert log entry")
+			} else {
+				l.notifyChange()
 			}
 
 			// Cleanup old entries if needed
@@ -176,6 +237,7 @@ func (l *DBLogger) LogRequest(ctx context.Context, requestID, method, path, topi
 		// Entry queued successfully
 	default:
 		// Queue full, log and drop
+		l.dropped.Add(1)
 		log.Warn().Str("requestID", requestID).Msg("DB logger queue full, dropping log entry")
 	}
 }
@@ -215,10 +277,21 @@ func (l *DBLogger) LogResponse(requestID string, statusCode int, responseBody in
 		// Entry queued successfully
 	default:
 		// Queue full, log and drop
+		l.dropped.Add(1)
 		log.Warn().Str("requestID", requestID).Msg("DB logger queue full, dropping response log entry")
 	}
 }
 
+// QueueDepth returns the number of entries waiting to be written
+func (l *DBLogger) QueueDepth() int {
+	return len(l.queue)
+}
+
+// Dropped returns the number of entries dropped because the queue was full
+func (l *DBLogger) Dropped() int64 {
+	return l.dropped.Load()
+}
+
 // insertLogEntry inserts or updates a log entry in the database
 func (l *DBLogger) insertLogEntry(entry *RequestLogEntry) error {
 	l.mutex.Lock()
@@ -277,50 +350,21 @@ func (l *DBLogger) cleanupOldEntries() error {
 	return err
 }
 
-// GetEntries retrieves the latest log entries
-func (l *DBLogger) GetEntries(limit int) ([]RequestLogEntry, error) {
-	if !l.enabled {
-		return nil, nil
-	}
-
-	l.mutex.Lock()
-	defer l.mutex.Unlock()
-
-	if limit <= 0 || limit > l.maxEntries {
-		limit = l.maxEntries
-	}
-
-	rows, err := l.db.Query(`
-		SELECT id, request_id, method, path, topic, request_body, response_body, 
-		       status_code, response_time, timestamp, response_topic, error
-		FROM request_logs 
-		ORDER BY timestamp DESC LIMIT ?
-	`, limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var entries []RequestLogEntry
-	for rows.Next() {
-		var entry RequestLogEntry
-		var timestamp string
-
-		err := rows.Scan(
-			&entry.ID, &entry.RequestID, &entry.Method, &entry.Path, &entry.Topic,
-			&entry.RequestBody, &entry.ResponseBody, &entry.StatusCode, &entry.ResponseTime,
-			&timestamp, &entry.ResponseTopic, &entry.Error,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		// Parse timestamp
-		entry.Timestamp, _ = time.Parse("2006-01-02 15:04:05", timestamp)
-		entries = append(entries, entry)
-	}
+// logEntryColumns are the columns read into a RequestLogEntry. Responses are
+// NULL until a request is answered.
+const logEntryColumns = `id, request_id, method, path, topic, COALESCE(request_body, ''),
+	COALESCE(response_body, ''), COALESCE(status_code, 0), COALESCE(response_time, 0),
+	timestamp, COALESCE(response_topic, ''), COALESCE(error, ''), COALESCE(seq, 0)`
 
-	return entries, nil
+// scanLogEntry reads a row of logEntryColumns
+func scanLogEntry(row interface{ Scan(dest ...any) error }) (RequestLogEntry, error) {
+	var entry RequestLogEntry
+	err := row.Scan(
+		&entry.ID, &entry.RequestID, &entry.Method, &entry.Path, &entry.Topic,
+		&entry.RequestBody, &entry.ResponseBody, &entry.StatusCode, &entry.ResponseTime,
+		&entry.Timestamp, &entry.ResponseTopic, &entry.Error, &entry.Seq,
+	)
+	return entry, err
 }
 
 // GetEntry retrieves a specific log entry by request ID
@@ -332,29 +376,14 @@ func (l *DBLogger) GetEntry(requestID string) (*RequestLogEntry, error) {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
-	var entry RequestLogEntry
-	var timestamp string
-
-	err := l.db.QueryRow(`
-		SELECT id, request_id, method, path, topic, request_body, response_body, 
-		       status_code, response_time, timestamp, response_topic, error
-		FROM request_logs 
-		WHERE request_id = ?
-	`, requestID).Scan(
-		&entry.ID, &entry.RequestID, &entry.Method, &entry.Path, &entry.Topic,
-		&entry.RequestBody, &entry.ResponseBody, &entry.StatusCode, &entry.ResponseTime,
-		&timestamp, &entry.ResponseTopic, &entry.Error,
-	)
-
+	row := l.db.QueryRow("SELECT "+logEntryColumns+" FROM request_logs WHERE request_id = ?", requestID)
+	entry, err := scanLogEntry(row)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	} else if err != nil {
 		return nil, err
 	}
 
-	// Parse timestamp
-	entry.Timestamp, _ = time.Parse("2006-01-02 15:04:05", timestamp)
-
 	return &entry, nil
 }
 
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+// dispatcherHealthInterval is how long a shard waits for traffic before pinging Redis
+const dispatcherHealthInterval = 30 * time.Second
+
+// responseDispatcher holds a small set of pattern subscriptions on the reply
+// prefix of this proxy instance and routes incoming replies to waiting requests
+// by correlation ID, so that in-flight requests don't need a connection each
+type responseDispatcher struct {
+	client  redis.UniversalClient
+	prefix  string // <reply prefix>:<instance ID>
+	shards  []*redis.PubSub
+	replies *replyRegistry
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	logger  zerolog.Logger
+
+	// down counts the shard subscriptions currently being re-established
+	down atomic.Int32
+}
+
+// newResponseDispatcher subscribes to the reply topics of this instance and starts
+// routing replies. It returns once every shard subscription is confirmed.
+func newResponseDispatcher(client redis.UniversalClient, config Config, logger zerolog.Logger) (*responseDispatcher, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	d := &responseDispatcher{
+		client:  client,
+		prefix:  fmt.Sprintf("%s:%s", config.ReplyPrefix, config.InstanceID),
+		replies: newReplyRegistry(),
+		cancel:  cancel,
+		logger:  logger.With().Str("subcomponent", "responseDispatcher").Logger(),
+	}
+
+	for shard := 0; shard < config.ReplyShards; shard++ {
+		pattern := fmt.Sprintf("%s:%d:*", d.prefix, shard)
+		pubsub := client.PSubscribe(ctx, pattern)
+
+		// Make sure the subscription is established before accepting requests
+		subscribeCtx, subscribeCancel := context.WithTimeout(ctx, 5*time.Second)
+		_, err := pubsub.Receive(subscribeCtx)
+		subscribeCancel()
+		if err != nil {
+			pubsub.Close()
+			d.Close()
+			return nil, fmt.Errorf("failed to subscribe to reply pattern %s: %w", pattern, err)
+		}
+
+		d.shards = append(d.shards, pubsub)
+		d.wg.Add(1)
+		go d.run(ctx, shard, pubsub)
+	}
+
+	d.logger.Info().Str("prefix", d.prefix).Int("shards", len(d.shards)).Msg("Response dispatcher started")
+	return d, nil
+}
+
+// ReplyTopic returns the reply topic for a correlation ID
+func (d *responseDispatcher) ReplyTopic(correlationID string) string {
+	return fmt.Sprintf("%s:%d:%s", d.prefix, d.shardFor(correlationID), correlationID)
+}
+
+// Register creates a waiter for the replies to a correlation ID. The waiter must
+// be registered before the request is published and closed once it is done.
+func (d *responseDispatcher) Register(correlationID string) *ReplyWaiter {
+	return d.replies.register(d.ReplyTopic(correlationID), correlationID)
+}
+
+// Healthy returns an error if a shard subscription is down
+func (d *responseDispatcher) Healthy() error {
+	if down := d.down.Load(); down > 0 {
+		return fmt.Errorf("%d of %d reply subscriptions down", down, len(d.shards))
+	}
+	return nil
+}
+
+// shardFor maps a correlation ID to one of the shard subscriptions
+func (d *responseDispatcher) shardFor(correlationID string) int {
+	if len(d.shards) <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(correlationID))
+	return int(h.Sum32() % uint32(len(d.shards)))
+}
+
+// run receives messages for one shard until the dispatcher is closed. go-redis
+// reconnects and re-subscribes on connection errors, to the new master after a
+// Sentinel failover and to another node in a cluster. Replies published while the
+// connection was down are lost, so waiting requests fall back to their timeout.
+func (d *responseDispatcher) run(ctx context.Context, shard int, pubsub *redis.PubSub) {
+	defer d.wg.Done()
+
+	logger := d.logger.With().Int("shard", shard).Logger()
+	subscribed := true
+	backoff := 100 * time.Millisecond
+
+	for {
+		msg, err := pubsub.ReceiveTimeout(ctx, dispatcherHealthInterval)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			var netErr interface{ Timeout() bool }
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				// No traffic for a while, ping to detect dead connections
+				if err := pubsub.Ping(ctx); err != nil && ctx.Err() == nil {
+					logger.Warn().Err(err).Msg("Reply subscription ping failed")
+				}
+				continue
+			}
+
+			if subscribed {
+				subscribed = false
+				d.down.Add(1)
+				d.replies.interrupted()
+			}
+			logger.Warn().Err(err).Dur("backoff", backoff).Msg("Reply subscription error, reconnecting")
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			if backoff < 5*time.Second {
+				backoff *= 2
+			}
+			continue
+		}
+
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			if m.Kind == "psubscribe" && !subscribed {
+				subscribed = true
+				d.down.Add(-1)
+				backoff = 100 * time.Millisecond
+				logger.Warn().Int("inFlight", d.replies.InFlight()).
+					Msg("Reply subscription re-established, replies sent while disconnected are lost")
+			}
+		case *redis.Message:
+			d.dispatch(logger, m)
+		case *redis.Pong:
+			// Health check response
+		}
+	}
+}
+
+// dispatch delivers a reply to the waiter registered for its correlation ID
+func (d *responseDispatcher) dispatch(logger zerolog.Logger, msg *redis.Message) {
+	correlationID := msg.Channel[strings.LastIndex(msg.Channel, ":")+1:]
+	d.replies.deliver(logger, msg.Channel, correlationID, msg.Payload)
+}
+
+// Close stops all shard subscriptions
+func (d *responseDispatcher) Close() error {
+	d.cancel()
+	for _, pubsub := range d.shards {
+		pubsub.Close()
+	}
+	d.wg.Wait()
+	return nil
+}
@@ -185,6 +185,137 @@ test_async_mode() {
   fi
 }
 
+# Function to test the streams transport
+test_streams_mode() {
+  echo -e "\n${YELLOW}Testing Streams Transport:${NC}"
+
+  # Stop the previous proxy
+  if [ -n "$PROXY_PID" ]; then
+    kill $PROXY_PID
+    wait $PROXY_PID 2>/dev/null
+    echo "Previous proxy stopped"
+  fi
+
+  # Start an echo server consuming the stream through a consumer group
+  echo -n "Starting Echo Server in streams mode... "
+  cd sample-backend
+  REDIS_ADDR=localhost:6379 REDIS_TOPICS=api:stream-test TRANSPORT=streams DEBUG=true go run . > ../echo-server-streams.log 2>&1 &
+  STREAM_ECHO_PID=$!
+  cd ..
+  sleep 2
+
+  if ps -p $STREAM_ECHO_PID > /dev/null; then
+    echo -e "${GREEN}Started (PID: $STREAM_ECHO_PID)${NC}"
+  else
+    echo -e "${RED}Failed${NC}"
+    return 1
+  fi
+
+  echo -n "Starting HTTP Proxy in streams mode... "
+  PORT=8080 REDIS_ADDR=localhost:6379 RESPONSE_TIMEOUT=10 TRANSPORT=streams DEBUG=true go run . > proxy-streams.log 2>&1 &
+  PROXY_PID=$!
+  sleep 2
+
+  if ps -p $PROXY_PID > /dev/null; then
+    echo -e "${GREEN}Started (PID: $PROXY_PID)${NC}"
+  else
+    echo -e "${RED}Failed${NC}"
+    return 1
+  fi
+
+  echo -n "Sending request to /api/stream-test... "
+  RESPONSE=$(curl -s -w "\n%{http_code}" -X POST -H "Content-Type: application/json" -d '{"test":"streams"}' http://localhost:8080/api/stream-test)
+  STATUS=$(echo "$RESPONSE" | tail -n1)
+  BODY=$(echo "$RESPONSE" | sed '$d')
+
+  if [ "$STATUS" = "200" ]; then
+    echo -e "${GREEN}Success (Status: $STATUS)${NC}"
+    echo "Response: $BODY"
+
+    # Verify the entry was acknowledged by the consumer group
+    PENDING=$(redis-cli XPENDING api:stream-test echo-servers | head -n1)
+    if [ "$PENDING" = "0" ]; then
+      echo -e "${GREEN}✓ Stream entry acknowledged${NC}"
+    else
+      echo -e "${RED}✗ Stream entry still pending ($PENDING)${NC}"
+    fi
+  else
+    echo -e "${RED}Failed (Status: $STATUS)${NC}"
+    echo "Response: $BODY"
+  fi
+}
+
+# Function to test the queue transport
+test_queue_mode() {
+  echo -e "\n${YELLOW}Testing Queue Transport:${NC}"
+
+  # Stop the previous proxy
+  if [ -n "$PROXY_PID" ]; then
+    kill $PROXY_PID
+    wait $PROXY_PID 2>/dev/null
+    echo "Previous proxy stopped"
+  fi
+
+  # Start two workers on the same queue, each message must be handled once
+  echo -n "Starting Echo Servers in queue mode... "
+  cd sample-backend
+  REDIS_ADDR=localhost:6379 REDIS_TOPICS=api:queue-test TRANSPORT=queue CONSUMER_NAME=worker-1 go run . > ../echo-server-queue-1.log 2>&1 &
+  QUEUE_ECHO_PID_1=$!
+  REDIS_ADDR=localhost:6379 REDIS_TOPICS=api:queue-test TRANSPORT=queue CONSUMER_NAME=worker-2 go run . > ../echo-server-queue-2.log 2>&1 &
+  QUEUE_ECHO_PID_2=$!
+  cd ..
+  sleep 2
+
+  if ps -p $QUEUE_ECHO_PID_1 > /dev/null && ps -p $QUEUE_ECHO_PID_2 > /dev/null; then
+    echo -e "${GREEN}Started (PIDs: $QUEUE_ECHO_PID_1, $QUEUE_ECHO_PID_2)${NC}"
+  else
+    echo -e "${RED}Failed${NC}"
+    return 1
+  fi
+
+  echo -n "Starting HTTP Proxy in queue mode... "
+  PORT=8080 REDIS_ADDR=localhost:6379 RESPONSE_TIMEOUT=10 TRANSPORT=queue DEBUG=true go run . > proxy-queue.log 2>&1 &
+  PROXY_PID=$!
+  sleep 2
+
+  if ps -p $PROXY_PID > /dev/null; then
+    echo -e "${GREEN}Started (PID: $PROXY_PID)${NC}"
+  else
+    echo -e "${RED}Failed${NC}"
+    return 1
+  fi
+
+  echo -n "Sending 10 requests to /api/queue-test... "
+  SUCCESS=0
+  for i in $(seq 1 10); do
+    STATUS=$(curl -s -o /dev/null -w "%{http_code}" -X POST -H "Content-Type: application/json" -d "{\"test\":\"queue\",\"n\":$i}" http://localhost:8080/api/queue-test)
+    if [ "$STATUS" = "200" ]; then
+      SUCCESS=$((SUCCESS + 1))
+    fi
+  done
+
+  if [ "$SUCCESS" = "10" ]; then
+    echo -e "${GREEN}Success (10/10)${NC}"
+  else
+    echo -e "${RED}Failed ($SUCCESS/10)${NC}"
+  fi
+
+  HANDLED=$(cat echo-server-queue-1.log echo-server-queue-2.log | grep -c "Echoed message")
+  if [ "$HANDLED" = "10" ]; then
+    echo -e "${GREEN}✓ Each message handled by exactly one worker${NC}"
+  else
+    echo -e "${RED}✗ Expected 10 handled messages, got $HANDLED${NC}"
+  fi
+
+  PROCESSING=$(redis-cli LLEN api:queue-test:processing:worker-1)
+  PROCESSING=$((PROCESSING + $(redis-cli LLEN api:queue-test:processing:worker-2)))
+  if [ "$PROCESSING" = "0" ]; then
+    echo -e "${GREEN}✓ Processing lists drained${NC}"
+  else
+    echo -e "${RED}✗ $PROCESSING messages left in processing lists${NC}"
+  fi
+}
+
 # Function to clean up
 cleanup() {
   echo -e "\n${YELLOW}Cleaning up:${NC}"
@@ -202,6 +333,20 @@ cleanup() {
     wait $ECHO_PID 2>/dev/null
     echo -e "${GREEN}Done${NC}"
   fi
+
+  for PID in $QUEUE_ECHO_PID_1 $QUEUE_ECHO_PID_2; do
+    echo -n "Stopping Echo Server (queue mode)... "
+    kill $PID
+    wait $PID 2>/dev/null
+    echo -e "${GREEN}Done${NC}"
+  done
+
+  if [ -n "$STREAM_ECHO_PID" ]; then
+    echo -n "Stopping Echo Server (streams mode)... "
+    kill $STREAM_ECHO_PID
+    wait $STREAM_ECHO_PID 2>/dev/null
+    echo -e "${GREEN}Done${NC}"
+  fi
   
   echo -e "\n${GREEN}Integration tests completed${NC}"
   
@@ -210,7 +355,11 @@ cleanup() {
   echo "  - proxy.log: HTTP Proxy (path-based mode)"
   echo "  - proxy-fixed.log: HTTP Proxy (fixed topic mode)"
   echo "  - proxy-async.log: HTTP Proxy (async mode)"
+  echo "  - proxy-streams.log: HTTP Proxy (streams transport)"
   echo "  - echo-server.log: Echo Server"
+  echo "  - proxy-queue.log: HTTP Proxy (queue transport)"
+  echo "  - echo-server-streams.log: Echo Server (streams mode)"
+  echo "  - echo-server-queue-*.log: Echo Servers (queue mode)"
 }
 
 # Set up trap to clean up on exit
@@ -225,5 +374,7 @@ start_proxy || exit 1
 test_path_based
 test_fixed_topic
 test_async_mode
+test_streams_mode
+test_queue_mode
 
 echo -e "\n${GREEN}All tests completed successfully!${NC}"
\ No newline at end of file
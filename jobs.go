@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Job states
+const (
+	JobPending = "pending"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Job represents an asynchronous request and, once available, the backend reply
+type Job struct {
+	ID          string                 `json:"job_id"`
+	State       string                 `json:"state"`
+	Method      string                 `json:"method"`
+	Path        string                 `json:"path"`
+	Topic       string                 `json:"topic"`
+	CreatedAt   time.Time              `json:"created_at"`
+	CompletedAt *time.Time             `json:"completed_at,omitempty"`
+	Status      int                    `json:"status,omitempty"`
+	Headers     map[string]interface{} `json:"headers,omitempty"`
+	ContentType string                 `json:"content_type,omitempty"`
+	Body        interface{}            `json:"body,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+}
+
+// JobStore keeps jobs in the broker's key-value storage until their TTL expires
+type JobStore struct {
+	broker Broker
+	prefix string
+	ttl    time.Duration
+}
+
+// NewJobStore creates a job store on the key-value storage of a broker
+func NewJobStore(broker Broker, config Config) *JobStore {
+	return &JobStore{
+		broker: broker,
+		prefix: config.JobKeyPrefix,
+		ttl:    time.Duration(config.JobTTL) * time.Second,
+	}
+}
+
+// key returns the storage key of a job
+func (js *JobStore) key(id string) string {
+	return js.prefix + ":" + id
+}
+
+// Save stores a job, resetting its TTL
+func (js *JobStore) Save(ctx context.Context, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return js.broker.SetValue(ctx, js.key(job.ID), data, js.ttl)
+}
+
+// Get returns a job, or nil if it doesn't exist or has expired
+func (js *JobStore) Get(ctx context.Context, id string) (*Job, error) {
+	data, err := js.broker.GetValue(ctx, js.key(id))
+	if errors.Is(err, ErrValueNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("invalid job data: %w", err)
+	}
+	return &job, nil
+}
+
+// wantsAsyncJob reports whether a request should be handled as an asynchronous job
+func (ps *ProxyServer) wantsAsyncJob(r *http.Request) bool {
+	if ps.cfg().AsyncJobs {
+		return true
+	}
+	for _, value := range r.Header.Values("Prefer") {
+		for _, preference := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(preference), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// startJob publishes a message as an asynchronous job, responds with 202 and a
+// Location header, and waits for the reply in the background
+func (ps *ProxyServer) startJob(w http.ResponseWriter, r *http.Request, logger zerolog.Logger, job *Job, message Message,
+	timeout int, startTime time.Time) {
+	ctx := r.Context()
+
+	if err := ps.jobStore.Save(ctx, job); err != nil {
+		logger.Error().Err(err).Msg("Error storing job")
+
+		if ps.dbLogger != nil && ps.dbLogger.enabled {
+			ps.dbLogger.LogResponse(job.ID, http.StatusInternalServerError, nil, time.Since(startTime), err)
+		}
+
+		http.Error(w, "Error storing job", http.StatusInternalServerError)
+		return
+	}
+
+	// Register for the reply BEFORE publishing the message
+	waiter := ps.awaitReply(ctx, job.ID)
+
+	logger.Debug().Str("topic", job.Topic).Msg("Publishing job message")
+	if err := ps.publish(ctx, logger, job.Topic, message); err != nil {
+		waiter.Close()
+		ps.finishJob(logger, job, nil, err)
+		ps.handlePublishError(w, logger, job.ID, job.Topic, startTime, err)
+		return
+	}
+
+	// Wait for the reply in the background, independent of the client connection
+	ps.backgroundWG.Add(1)
+	go func() {
+		defer ps.backgroundWG.Done()
+		defer waiter.Close()
+		ps.awaitJobReply(logger, job, waiter, timeout, startTime)
+	}()
+
+	location := strings.TrimSuffix(ps.cfg().JobsPath, "/") + "/" + job.ID
+	logger.Debug().Str("location", location).Msg("Job accepted")
+
+	if ps.dbLogger != nil && ps.dbLogger.enabled {
+		ps.dbLogger.LogResponse(job.ID, http.StatusAccepted, nil, time.Since(startTime), nil)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"job_id":   job.ID,
+		"state":    JobPending,
+		"location": location,
+	})
+}
+
+// awaitJobReply waits up to timeout seconds for the backend reply of a job and stores the result
+func (ps *ProxyServer) awaitJobReply(logger zerolog.Logger, job *Job, waiter *ReplyWaiter, timeout int, startTime time.Time) {
+	timer := time.NewTimer(time.Duration(timeout) * time.Second)
+	defer timer.Stop()
+
+	var response *Response
+	var statusCode int
+	var err error
+
+	select {
+	case payload := <-waiter.Replies():
+		response, err = parseResponse(payload)
+		if err != nil {
+			logger.Error().Err(err).Str("payload", truncateString(payload, 500)).Msg("Error parsing job response")
+			statusCode = http.StatusInternalServerError
+			err = fmt.Errorf("error parsing response: %w", err)
+		} else {
+			statusCode = response.Status
+		}
+	case <-timer.C:
+		logger.Error().Int("timeout", timeout).Msg("Job response timeout")
+		statusCode = http.StatusGatewayTimeout
+		err = replyTimeoutError(timeout, waiter)
+		ps.metrics.Timeout(job.Topic, timeoutJob)
+		ps.cancelRequest(logger, job.Topic, job.ID, err)
+	case <-ps.backgroundCtx.Done():
+		statusCode = http.StatusServiceUnavailable
+		err = fmt.Errorf("proxy shut down before the response arrived")
+		ps.cancelRequest(logger, job.Topic, job.ID, err)
+	}
+
+	ps.finishJob(logger, job, response, err)
+
+	// Update the request log with the final job outcome
+	if ps.dbLogger != nil && ps.dbLogger.enabled {
+		if err != nil {
+			ps.dbLogger.LogResponse(job.ID, statusCode, nil, time.Since(startTime), err)
+		} else {
+			ps.dbLogger.LogResponse(job.ID, statusCode, response.Body, time.Since(startTime), nil)
+		}
+	}
+}
+
+// finishJob stores the final state of a job
+func (ps *ProxyServer) finishJob(logger zerolog.Logger, job *Job, response *Response, err error) {
+	now := time.Now()
+	job.CompletedAt = &now
+
+	if err != nil {
+		job.State = JobFailed
+		job.Error = err.Error()
+	} else {
+		job.State = JobDone
+		job.Status = response.Status
+		job.Headers = response.Headers
+		job.ContentType = response.ContentType
+		job.Body = response.Body
+	}
+
+	// Use a fresh context, the job may outlive both the request and the proxy shutdown signal
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ps.jobStore.Save(ctx, job); err != nil {
+		logger.Error().Err(err).Msg("Error storing job result")
+		return
+	}
+	logger.Debug().Str("state", job.State).Msg("Job finished")
+}
+
+// handleJobStatus serves the state and result of an asynchronous job
+func (ps *ProxyServer) handleJobStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(ps.cfg().JobsPath, "/")+"/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	job, err := ps.jobStore.Get(r.Context(), id)
+	if err != nil {
+		log.Error().Err(err).Str("jobID", id).Msg("Error retrieving job")
+		http.Error(w, "Error retrieving job", http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if job.State == JobPending {
+		w.Header().Set("Retry-After", "1")
+	}
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		log.Error().Err(err).Msg("Error encoding job")
+	}
+}
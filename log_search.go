@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// errInvalidLogFilter is returned for log queries with invalid filter values
+var errInvalidLogFilter = errors.New("invalid log filter")
+
+// likeEscaper escapes the wildcards of a LIKE pattern, which uses '\' as escape character
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// LogFilter selects the log entries returned by FindEntries. Zero values don't filter.
+type LogFilter struct {
+	Topic           string
+	PathPrefix      string
+	Method          string
+	StatusMin       int
+	StatusMax       int
+	ErrorsOnly      bool  // Only requests answered with an error status or an error message
+	MinResponseTime int64 // Milliseconds
+	From            time.Time
+	To              time.Time
+	Search          string // Words that must all appear in the request or response body
+	Cursor          int64  // Only entries older than the entry with this ID
+	Limit           int
+}
+
+// parseLogFilter reads a log filter from the query parameters of a request
+func parseLogFilter(r *http.Request) (LogFilter, error) {
+	params := r.URL.Query()
+	filter := LogFilter{
+		Topic:      params.Get("topic"),
+		PathPrefix: params.Get("path_prefix"),
+		Method:     strings.ToUpper(params.Get("method")),
+		Search:     strings.TrimSpace(params.Get("q")),
+		Limit:      1000, // Default to 1000 entries
+	}
+
+	if parsed, err := strconv.Atoi(params.Get("limit")); err == nil && parsed > 0 {
+		filter.Limit = parsed
+	}
+
+	ints := []struct {
+		name  string
+		value *int64
+	}{
+		{"cursor", &filter.Cursor},
+		{"min_response_time_ms", &filter.MinResponseTime},
+	}
+	for _, param := range ints {
+		if value := params.Get(param.name); value != "" {
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || parsed < 0 {
+				return filter, fmt.Errorf("%w: %s must be a non-negative integer", errInvalidLogFilter, param.name)
+			}
+			*param.value = parsed
+		}
+	}
+
+	statuses := []struct {
+		name  string
+		value *int
+	}{
+		{"status_min", &filter.StatusMin},
+		{"status_max", &filter.StatusMax},
+	}
+	for _, param := range statuses {
+		if value := params.Get(param.name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 100 || parsed > 599 {
+				return filter, fmt.Errorf("%w: %s must be a status code", errInvalidLogFilter, param.name)
+			}
+			*param.value = parsed
+		}
+	}
+	if filter.StatusMin > 0 && filter.StatusMax > 0 && filter.StatusMin > filter.StatusMax {
+		return filter, fmt.Errorf("%w: status_min must not be above status_max", errInvalidLogFilter)
+	}
+
+	if value := params.Get("errors"); value != "" {
+		errorsOnly, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("%w: errors must be a boolean", errInvalidLogFilter)
+		}
+		filter.ErrorsOnly = errorsOnly
+	}
+
+	var err error
+	if value := params.Get("from"); value != "" {
+		if filter.From, err = parseTimeParam(value); err != nil {
+			return filter, fmt.Errorf("%w: from: %v", errInvalidLogFilter, err)
+		}
+	}
+	if value := params.Get("to"); value != "" {
+		if filter.To, err = parseTimeParam(value); err != nil {
+			return filter, fmt.Errorf("%w: to: %v", errInvalidLogFilter, err)
+		}
+	}
+	return filter, nil
+}
+
+// initFullTextSearch creates an FTS5 index over the request and response bodies,
+// kept up to date by triggers. FTS5 is only available if SQLite was built with the
+// sqlite_fts5 build tag, otherwise searches fall back to LIKE.
+func (l *DBLogger) initFullTextSearch() error {
+	var available bool
+	err := l.db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&available)
+	if err != nil {
+		return err
+	}
+	if !available {
+		// Triggers left by a build with FTS5 would make every insert fail
+		for _, trigger := range []string{"request_logs_fts_insert", "request_logs_fts_delete", "request_logs_fts_update"} {
+			if _, err := l.db.Exec("DROP TRIGGER IF EXISTS " + trigger); err != nil {
+				return err
+			}
+		}
+		log.Info().Msg("SQLite was built without FTS5, log searches use LIKE")
+		return nil
+	}
+
+	_, err = l.db.Exec(`
+		CREATE VIRTUAL TABLE IF NOT EXISTS request_logs_fts
+		USING fts5(request_body, response_body, content='request_logs', content_rowid='id')
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Entries written while the triggers were missing are only indexed by a rebuild
+	var triggers int
+	err = l.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'request_logs_fts_%'`).Scan(&triggers)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.db.Exec(`
+		CREATE TRIGGER IF NOT EXISTS request_logs_fts_insert AFTER INSERT ON request_logs BEGIN
+			INSERT INTO request_logs_fts (rowid, request_body, response_body)
+			VALUES (new.id, new.request_body, new.response_body);
+		END;
+		CREATE TRIGGER IF NOT EXISTS request_logs_fts_delete AFTER DELETE ON request_logs BEGIN
+			INSERT INTO request_logs_fts (request_logs_fts, rowid, request_body, response_body)
+			VALUES ('delete', old.id, old.request_body, old.response_body);
+		END;
+		CREATE TRIGGER IF NOT EXISTS request_logs_fts_update AFTER UPDATE OF request_body, response_body ON request_logs BEGIN
+			INSERT INTO request_logs_fts (request_logs_fts, rowid, request_body, response_body)
+			VALUES ('delete', old.id, old.request_body, old.response_body);
+			INSERT INTO request_logs_fts (rowid, request_body, response_body)
+			VALUES (new.id, new.request_body, new.response_body);
+		END;
+	`)
+	if err != nil {
+		return err
+	}
+
+	if triggers < 3 {
+		if _, err := l.db.Exec(`INSERT INTO request_logs_fts (request_logs_fts) VALUES ('rebuild')`); err != nil {
+			return err
+		}
+	}
+
+	l.ftsEnabled = true
+	return nil
+}
+
+// FindEntries retrieves the latest log entries matching a filter, newest first.
+// The returned cursor selects the next page, it is zero after the last page.
+func (l *DBLogger) FindEntries(ctx context.Context, filter LogFilter) ([]RequestLogEntry, int64, error) {
+	if !l.enabled {
+		return nil, 0, nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	limit := filter.Limit
+	if limit <= 0 || limit > l.maxEntries {
+		limit = l.maxEntries
+	}
+
+	conditions, args := l.filterConditions(filter)
+	if filter.Cursor > 0 {
+		conditions = append(conditions, "id < ?")
+		args = append(args, filter.Cursor)
+	}
+
+	// One more entry than requested tells whether there is a next page
+	query := "SELECT " + logEntryColumns + " FROM request_logs" + whereClause(conditions) + " ORDER BY id DESC LIMIT ?"
+	entries, err := l.selectEntries(ctx, query, append(args, limit+1)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var cursor int64
+	if len(entries) > limit {
+		entries = entries[:limit]
+		cursor = entries[limit-1].ID
+	}
+	return entries, cursor, nil
+}
+
+// filterConditions returns the conditions selecting the entries of a filter and
+// their arguments. The cursor and limit of the filter are left to the caller.
+func (l *DBLogger) filterConditions(filter LogFilter) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, values ...interface{}) {
+		conditions = append(conditions, condition)
+		args = append(args, values...)
+	}
+
+	if filter.Topic != "" {
+		add("topic = ?", filter.Topic)
+	}
+	if filter.PathPrefix != "" {
+		add(`path LIKE ? ESCAPE '\'`, likeEscaper.Replace(filter.PathPrefix)+"%")
+	}
+	if filter.Method != "" {
+		add("method = ?", filter.Method)
+	}
+	if filter.StatusMin > 0 {
+		add("status_code >= ?", filter.StatusMin)
+	}
+	if filter.StatusMax > 0 {
+		add("status_code <= ?", filter.StatusMax)
+	}
+	if filter.ErrorsOnly {
+		add(failedRequestCondition)
+	}
+	if filter.MinResponseTime > 0 {
+		add("response_time >= ?", filter.MinResponseTime)
+	}
+	// Requests are compared by their local time, as they are stored
+	if !filter.From.IsZero() {
+		add("timestamp >= datetime(?)", filter.From.Local().Format("2006-01-02 15:04:05"))
+	}
+	if !filter.To.IsZero() {
+		add("timestamp < datetime(?)", filter.To.Local().Format("2006-01-02 15:04:05"))
+	}
+
+	if words := strings.Fields(filter.Search); len(words) > 0 {
+		if l.ftsEnabled {
+			// Every word is quoted, so that FTS5 query syntax is matched literally
+			phrases := make([]string, len(words))
+			for i, word := range words {
+				phrases[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+			}
+			add("id IN (SELECT rowid FROM request_logs_fts WHERE request_logs_fts MATCH ?)", strings.Join(phrases, " "))
+		} else {
+			for _, word := range words {
+				pattern := "%" + likeEscaper.Replace(word) + "%"
+				add(`(request_body LIKE ? ESCAPE '\' OR response_body LIKE ? ESCAPE '\')`, pattern, pattern)
+			}
+		}
+	}
+	return conditions, args
+}
+
+// whereClause joins conditions into a WHERE clause, which is empty without conditions
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// selectEntries runs a query of logEntryColumns
+func (l *DBLogger) selectEntries(ctx context.Context, query string, args ...interface{}) ([]RequestLogEntry, error) {
+	rows, err := l.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []RequestLogEntry
+	for rows.Next() {
+		entry, err := scanLogEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
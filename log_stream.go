@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// logStreamPollInterval is how often a log stream checks the database for
+	// entries written by other processes
+	logStreamPollInterval = time.Second
+	// logStreamKeepAlive is how often an idle log stream sends a comment, so
+	// that intermediaries don't close the connection
+	logStreamKeepAlive = 15 * time.Second
+	// logStreamBatchSize is the maximum number of entries read at once
+	logStreamBatchSize = 100
+)
+
+// initChangeSequence numbers every insert of a log entry and every response
+// written to it with an increasing sequence number. The numbers are assigned by
+// triggers, so that the log stream can tail the database even when another
+// process writes to it.
+func (l *DBLogger) initChangeSequence() error {
+	var columns int
+	err := l.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('request_logs') WHERE name = 'seq'`).Scan(&columns)
+	if err != nil {
+		return err
+	}
+	if columns == 0 {
+		// Entries of older databases are numbered in the order they were written
+		if _, err := l.db.Exec(`ALTER TABLE request_logs ADD COLUMN seq INTEGER`); err != nil {
+			return err
+		}
+		if _, err := l.db.Exec(`UPDATE request_logs SET seq = id`); err != nil {
+			return err
+		}
+	}
+
+	_, err = l.db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_seq ON request_logs(seq);
+		CREATE TABLE IF NOT EXISTS log_sequence (value INTEGER NOT NULL);
+		INSERT INTO log_sequence (value)
+			SELECT COALESCE(MAX(seq), 0) FROM request_logs WHERE NOT EXISTS (SELECT 1 FROM log_sequence);
+		CREATE TRIGGER IF NOT EXISTS request_logs_seq_insert AFTER INSERT ON request_logs BEGIN
+			UPDATE log_sequence SET value = value + 1;
+			UPDATE request_logs SET seq = (SELECT value FROM log_sequence) WHERE id = new.id;
+		END;
+		CREATE TRIGGER IF NOT EXISTS request_logs_seq_update
+		AFTER UPDATE OF response_body, status_code, response_time, error ON request_logs BEGIN
+			UPDATE log_sequence SET value = value + 1;
+			UPDATE request_logs SET seq = (SELECT value FROM log_sequence) WHERE id = new.id;
+		END;
+	`)
+	return err
+}
+
+// notifyChange wakes up the log streams waiting for changes
+func (l *DBLogger) notifyChange() {
+	l.changedMutex.Lock()
+	defer l.changedMutex.Unlock()
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// changes returns a channel that is closed once this process writes the next entry
+func (l *DBLogger) changes() <-chan struct{} {
+	l.changedMutex.Lock()
+	defer l.changedMutex.Unlock()
+	return l.changed
+}
+
+// LatestChange returns the sequence number of the latest change to the log entries
+func (l *DBLogger) LatestChange(ctx context.Context) (int64, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var seq int64
+	err := l.db.QueryRowContext(ctx, `SELECT value FROM log_sequence`).Scan(&seq)
+	return seq, err
+}
+
+// EntriesChangedSince retrieves up to limit entries matching a filter that were
+// written or answered after the change with sequence number seq, in the order of
+// their changes. It returns the sequence number to continue from, which also
+// skips changes of entries that don't match the filter.
+func (l *DBLogger) EntriesChangedSince(ctx context.Context, filter LogFilter, seq int64, limit int) ([]RequestLogEntry, int64, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var latest int64
+	if err := l.db.QueryRowContext(ctx, `SELECT value FROM log_sequence`).Scan(&latest); err != nil {
+		return nil, seq, err
+	}
+	if latest <= seq {
+		// A sequence number beyond the latest change is from a replaced database
+		return nil, latest, nil
+	}
+
+	conditions, args := l.filterConditions(filter)
+	conditions = append(conditions, "seq > ?", "seq <= ?")
+	query := "SELECT " + logEntryColumns + " FROM request_logs" + whereClause(conditions) + " ORDER BY seq LIMIT ?"
+	entries, err := l.selectEntries(ctx, query, append(args, seq, latest, limit)...)
+	if err != nil {
+		return nil, seq, err
+	}
+
+	if len(entries) == limit {
+		latest = entries[limit-1].Seq
+	}
+	return entries, latest, nil
+}
+
+// handleLogsStreamRequest streams log entries as Server-Sent Events while they are
+// written and answered, filtered like the logs API. Each event is named "request"
+// or "response", depending on whether the request was answered, and carries the
+// entry with its sequence number as event ID. Streams start after the latest change,
+// or after the change given by the Last-Event-ID header or the "since" parameter.
+// They end when the client disconnects or done is closed.
+func handleLogsStreamRequest(w http.ResponseWriter, r *http.Request, dbLogger *DBLogger, done <-chan struct{}) {
+	filter, err := parseLogFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	since := r.Header.Get("Last-Event-ID")
+	if since == "" {
+		since = r.URL.Query().Get("since")
+	}
+	var seq int64
+	if since != "" {
+		if seq, err = strconv.ParseInt(since, 10, 64); err != nil || seq < 0 {
+			http.Error(w, "since must be a sequence number", http.StatusBadRequest)
+			return
+		}
+	} else if seq, err = dbLogger.LatestChange(r.Context()); err != nil {
+		log.Error().Err(err).Msg("Error retrieving latest log change")
+		http.Error(w, "Error retrieving logs", http.StatusInternalServerError)
+		return
+	}
+
+	// The stream outlives the server write timeout
+	controller := http.NewResponseController(w)
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+		log.Debug().Err(err).Msg("Could not remove write deadline for log stream")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	controller.Flush()
+
+	poll := time.NewTicker(logStreamPollInterval)
+	defer poll.Stop()
+	keepAlive := time.NewTicker(logStreamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		// Taken before reading, so that entries written meanwhile wake up the stream
+		changed := dbLogger.changes()
+
+		entries, next, err := dbLogger.EntriesChangedSince(r.Context(), filter, seq, logStreamBatchSize)
+		if err != nil {
+			if r.Context().Err() == nil {
+				log.Error().Err(err).Msg("Error retrieving log changes")
+				fmt.Fprint(w, "event: error\ndata: Error retrieving logs\n\n")
+				controller.Flush()
+			}
+			return
+		}
+		seq = next
+
+		for _, entry := range entries {
+			if err := writeLogEvent(w, entry); err != nil {
+				return
+			}
+		}
+		if len(entries) > 0 {
+			controller.Flush()
+			keepAlive.Reset(logStreamKeepAlive)
+			if len(entries) == logStreamBatchSize {
+				continue
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-poll.C:
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			controller.Flush()
+		case <-r.Context().Done():
+			return
+		case <-done:
+			return
+		}
+	}
+}
+
+// writeLogEvent writes a log entry as Server-Sent Event
+func writeLogEvent(w http.ResponseWriter, entry RequestLogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	event := "request"
+	if entry.StatusCode != 0 {
+		event = "response"
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", entry.Seq, event, data)
+	return err
+}
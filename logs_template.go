@@ -61,6 +61,20 @@ const logsHTMLTemplate = `
             border-radius: 5px;
             box-shadow: 0 2px 4px rgba(0,0,0,0.1);
         }
+        #filters {
+            flex-wrap: wrap;
+        }
+        #filters input[type="text"] {
+            width: 140px;
+        }
+        #filter-q {
+            flex: 1;
+            min-width: 200px;
+        }
+        #load-more {
+            display: none;
+            margin: 0 auto 20px;
+        }
         .log-entry {
             background-color: white;
             border-radius: 5px;
@@ -156,9 +170,20 @@ const logsHTMLTemplate = `
             color: #666;
             font-size: 0.9em;
         }
-        #auto-refresh-container {
+        #live-container {
             margin-left: 20px;
         }
+        .live-status {
+            margin-left: 10px;
+            color: #666;
+        }
+        .live-status-live {
+            color: #4caf50;
+            font-weight: bold;
+        }
+        .live-status-offline {
+            color: #f44336;
+        }
         .footer {
             text-align: center;
             padding: 20px;
@@ -176,21 +201,16 @@ const logsHTMLTemplate = `
         <a href="/dashboard">Home</a>
         <a href="/dashboard/stats">Statistics</a>
         <a href="/dashboard/logs">Logs</a>
+        <a href="/dashboard/callbacks">Callbacks</a>
     </nav>
     
     <div class="container">
         <div class="controls">
             <button id="refresh-btn">Refresh</button>
             
-            <div id="auto-refresh-container">
-                <input type="checkbox" id="auto-refresh" name="auto-refresh">
-                <label for="auto-refresh">Auto refresh</label>
-                <select id="refresh-interval">
-                    <option value="5">5 seconds</option>
-                    <option value="10">10 seconds</option>
-                    <option value="30">30 seconds</option>
-                    <option value="60">1 minute</option>
-                </select>
+            <div id="live-container">
+                <button id="live-btn">Pause</button>
+                <span id="live-status" class="live-status">Connecting...</span>
             </div>
             
             <div style="margin-left: auto;">
@@ -205,9 +225,40 @@ const logsHTMLTemplate = `
             </div>
         </div>
         
+        <div class="controls" id="filters">
+            <input type="text" id="filter-q" placeholder="Search request and response bodies">
+            <input type="text" id="filter-topic" placeholder="Topic">
+            <input type="text" id="filter-path_prefix" placeholder="Path prefix">
+            <select id="filter-method">
+                <option value="">Any method</option>
+                <option value="GET">GET</option>
+                <option value="POST">POST</option>
+                <option value="PUT">PUT</option>
+                <option value="PATCH">PATCH</option>
+                <option value="DELETE">DELETE</option>
+            </select>
+            <select id="filter-status">
+                <option value="">Any status</option>
+                <option value="2">2xx</option>
+                <option value="3">3xx</option>
+                <option value="4">4xx</option>
+                <option value="5">5xx</option>
+            </select>
+            <label><input type="checkbox" id="filter-errors"> Errors only</label>
+            <input type="number" id="filter-min_response_time_ms" min="0" placeholder="Min ms" style="width: 90px;">
+            <label for="filter-from">From:</label>
+            <input type="datetime-local" id="filter-from">
+            <label for="filter-to">To:</label>
+            <input type="datetime-local" id="filter-to">
+            <button id="apply-filters-btn">Apply</button>
+            <button id="clear-filters-btn">Clear</button>
+        </div>
+        
         <div id="logs-container">
             <div class="loading">Loading logs...</div>
         </div>
+        
+        <button id="load-more">Load more</button>
     </div>
     
     <div class="footer">
@@ -215,7 +266,12 @@ const logsHTMLTemplate = `
     </div>
 
     <script>
-        let autoRefreshInterval;
+        let nextCursor = '';
+        let eventSource;
+        let liveSeq = 0;
+        let paused = false;
+        const entryElements = new Map(); // Shown entries by request ID
+        const maxEntries = 1000; // Entries shown before the oldest are dropped
         
         // Function to format JSON
         function formatJSON(json) {
@@ -256,137 +312,282 @@ const logsHTMLTemplate = `
             return '';
         }
 
-        // Function to fetch and display logs
-        function fetchLogs() {
-            const limit = document.getElementById('limit').value;
+        // Function to create the element of a log entry
+        function renderLogEntry(log) {
+            const logEntry = document.createElement('div');
+            logEntry.className = 'log-entry';
+            logEntry.dataset.id = log.id;
+            logEntry.dataset.requestId = log.request_id;
+            entryElements.set(log.request_id, logEntry);
+            
+            // Create log header
+            const logHeader = document.createElement('div');
+            logHeader.className = 'log-header';
+            
+            const requestInfo = document.createElement('div');
+            requestInfo.className = 'request-info';
+            requestInfo.textContent = (log.method || '') + " " + (log.path || '');
+            logHeader.appendChild(requestInfo);
+            
+            const statusContainer = document.createElement('div');
+            
+            if (log.status_code) {
+                const statusCode = document.createElement('span');
+                statusCode.className = 'status-code ' + getStatusCodeClass(log.status_code);
+                statusCode.textContent = log.status_code;
+                statusContainer.appendChild(statusCode);
+            }
+            
+            if (log.response_time_ms) {
+                const responseTime = document.createElement('span');
+                responseTime.className = 'response-time ' + getResponseTimeClass(log.response_time_ms);
+                responseTime.textContent = ' ' + log.response_time_ms + 'ms';
+                statusContainer.appendChild(responseTime);
+            }
+            
+            logHeader.appendChild(statusContainer);
+            logEntry.appendChild(logHeader);
+            
+            // Create details section
+            const details = document.createElement('div');
+            details.className = 'details';
+            
+            // Basic info
+            const basicInfo = document.createElement('div');
+            basicInfo.className = 'detail-item';
+            
+            const timestamp = document.createElement('div');
+            timestamp.className = 'timestamp';
+            timestamp.textContent = 'Time: ' + formatTimestamp(log.timestamp);
+            basicInfo.appendChild(timestamp);
+            
+            const requestID = document.createElement('div');
+            requestID.textContent = 'Request ID: ' + log.request_id;
+            basicInfo.appendChild(requestID);
+            
+            const topic = document.createElement('div');
+            topic.textContent = 'Topic: ' + log.topic;
+            basicInfo.appendChild(topic);
+            
+            if (log.response_topic) {
+                const responseTopic = document.createElement('div');
+                responseTopic.textContent = 'Response Topic: ' + log.response_topic;
+                basicInfo.appendChild(responseTopic);
+            }
+            
+            if (log.error) {
+                const error = document.createElement('div');
+                error.className = 'error';
+                error.textContent = 'Error: ' + log.error;
+                basicInfo.appendChild(error);
+            }
+            
+            details.appendChild(basicInfo);
+            
+            // Request body
+            if (log.request_body) {
+                const requestBody = document.createElement('div');
+                requestBody.className = 'detail-item';
+                
+                const requestTitle = document.createElement('div');
+                requestTitle.textContent = 'Request Body:';
+                requestBody.appendChild(requestTitle);
+                
+                const requestPre = document.createElement('pre');
+                requestPre.textContent = formatJSON(log.request_body);
+                requestBody.appendChild(requestPre);
+                
+                details.appendChild(requestBody);
+            }
+            
+            // Response body
+            if (log.response_body) {
+                const responseBody = document.createElement('div');
+                responseBody.className = 'detail-item';
+                
+                const responseTitle = document.createElement('div');
+                responseTitle.textContent = 'Response Body:';
+                responseBody.appendChild(responseTitle);
+                
+                const responsePre = document.createElement('pre');
+                responsePre.textContent = formatJSON(log.response_body);
+                responseBody.appendChild(responsePre);
+                
+                details.appendChild(responseBody);
+            }
+            
+            logEntry.appendChild(details);
+            return logEntry;
+        }
+
+        // Function to build the query of the selected filters
+        function buildQuery(cursor) {
+            const params = new URLSearchParams({limit: document.getElementById('limit').value});
+            ['q', 'topic', 'path_prefix', 'method', 'min_response_time_ms'].forEach(name => {
+                const value = document.getElementById('filter-' + name).value.trim();
+                if (value) params.set(name, value);
+            });
+            const status = document.getElementById('filter-status').value;
+            if (status) {
+                params.set('status_min', status + '00');
+                params.set('status_max', status + '99');
+            }
+            if (document.getElementById('filter-errors').checked) {
+                params.set('errors', 'true');
+            }
+            ['from', 'to'].forEach(name => {
+                const value = document.getElementById('filter-' + name).value;
+                if (value) params.set(name, new Date(value).toISOString().replace(/\.\d+Z$/, 'Z'));
+            });
+            if (cursor) {
+                params.set('cursor', cursor);
+            }
+            return params.toString();
+        }
+
+        // Function to fetch and display logs, appending the next page if more is true
+        function fetchLogs(more) {
             const logsContainer = document.getElementById('logs-container');
+            const loadMoreButton = document.getElementById('load-more');
+            const append = more === true && nextCursor;
             
-            logsContainer.innerHTML = '<div class="loading">Loading logs...</div>';
+            if (!append) {
+                logsContainer.innerHTML = '<div class="loading">Loading logs...</div>';
+                entryElements.clear();
+                stopStream();
+            }
+            loadMoreButton.style.display = 'none';
             
-            fetch('/dashboard/api/logs?limit=' + limit)
+            fetch('/dashboard/api/logs?' + buildQuery(append ? nextCursor : ''))
                 .then(response => {
                     if (!response.ok) {
-                        throw new Error('Failed to fetch logs');
+                        return response.text().then(text => {
+                            throw new Error(response.status === 400 ? text : 'Failed to fetch logs');
+                        });
                     }
+                    nextCursor = response.headers.get('X-Next-Cursor') || '';
                     return response.json();
                 })
                 .then(logs => {
-                    if (!logs || logs.length === 0) {
-                        logsContainer.innerHTML = '<div class="empty-message">No logs found</div>';
-                        return;
-                    }
-                    
-                    logsContainer.innerHTML = '';
-                    
-                    logs.forEach(log => {
-                        const logEntry = document.createElement('div');
-                        logEntry.className = 'log-entry';
-                        
-                        // Create log header
-                        const logHeader = document.createElement('div');
-                        logHeader.className = 'log-header';
+                    if (!append) {
+                        // The live tail continues after the latest change shown
+                        liveSeq = Math.max(0, ...(logs || []).map(log => log.seq));
+                        startStream();
                         
-                        const requestInfo = document.createElement('div');
-                        requestInfo.className = 'request-info';
-						requestInfo.textContent = (log.method || '') + " " + (log.path || '');
-                        logHeader.appendChild(requestInfo);
-                        
-                        const statusContainer = document.createElement('div');
-                        
-                        if (log.status_code) {
-                            const statusCode = document.createElement('span');
-                            statusCode.className = 'status-code ' + getStatusCodeClass(log.status_code);
-                            statusCode.textContent = log.status_code;
-                            statusContainer.appendChild(statusCode);
-                        }
-                        
-                        if (log.response_time_ms) {
-                            const responseTime = document.createElement('span');
-                            responseTime.className = 'response-time ' + getResponseTimeClass(log.response_time_ms);
-                            responseTime.textContent = ' ' + log.response_time_ms + 'ms';
-                            statusContainer.appendChild(responseTime);
+                        if (!logs || logs.length === 0) {
+                            logsContainer.innerHTML = '<div class="empty-message">No logs found</div>';
+                            return;
                         }
-                        
-                        logHeader.appendChild(statusContainer);
-                        logEntry.appendChild(logHeader);
-                        
-                        // Create details section
-                        const details = document.createElement('div');
-                        details.className = 'details';
-                        
-                        // Basic info
-                        const basicInfo = document.createElement('div');
-                        basicInfo.className = 'detail-item';
-                        
-                        const timestamp = document.createElement('div');
-                        timestamp.className = 'timestamp';
-                        timestamp.textContent = 'Time: ' + formatTimestamp(log.timestamp);
-                        basicInfo.appendChild(timestamp);
-                        
-                        const requestID = document.createElement('div');
-                        requestID.textContent = 'Request ID: ' + log.request_id;
-                        basicInfo.appendChild(requestID);
-                        
-                        const topic = document.createElement('div');
-                        topic.textContent = 'Topic: ' + log.topic;
-                        basicInfo.appendChild(topic);
-                        
-                        if (log.response_topic) {
-                            const responseTopic = document.createElement('div');
-                            responseTopic.textContent = 'Response Topic: ' + log.response_topic;
-                            basicInfo.appendChild(responseTopic);
-                        }
-                        
-                        if (log.error) {
-                            const error = document.createElement('div');
-                            error.className = 'error';
-                            error.textContent = 'Error: ' + log.error;
-                            basicInfo.appendChild(error);
-                        }
-                        
-                        details.appendChild(basicInfo);
-                        
-                        // Request body
-                        if (log.request_body) {
-                            const requestBody = document.createElement('div');
-                            requestBody.className = 'detail-item';
-                            
-                            const requestTitle = document.createElement('div');
-                            requestTitle.textContent = 'Request Body:';
-                            requestBody.appendChild(requestTitle);
-                            
-                            const requestPre = document.createElement('pre');
-                            requestPre.textContent = formatJSON(log.request_body);
-                            requestBody.appendChild(requestPre);
-                            
-                            details.appendChild(requestBody);
-                        }
-                        
-                        // Response body
-                        if (log.response_body) {
-                            const responseBody = document.createElement('div');
-                            responseBody.className = 'detail-item';
-                            
-                            const responseTitle = document.createElement('div');
-                            responseTitle.textContent = 'Response Body:';
-                            responseBody.appendChild(responseTitle);
-                            
-                            const responsePre = document.createElement('pre');
-                            responsePre.textContent = formatJSON(log.response_body);
-                            responseBody.appendChild(responsePre);
-                            
-                            details.appendChild(responseBody);
-                        }
-                        
-                        logEntry.appendChild(details);
-                        logsContainer.appendChild(logEntry);
-                    });
+                        logsContainer.innerHTML = '';
+                    }
+                    
+                    (logs || []).forEach(log => logsContainer.appendChild(renderLogEntry(log)));
+                    loadMoreButton.style.display = nextCursor ? 'block' : 'none';
                 })
                 .catch(error => {
                     logsContainer.innerHTML = '<div class="error">Error: ' + error.message + '</div>';
                 });
         }
 
+        // Function to set the status of the live tail
+        function setLiveStatus(text, state) {
+            const status = document.getElementById('live-status');
+            status.textContent = text;
+            status.className = 'live-status' + (state ? ' live-status-' + state : '');
+        }
+
+        // Function to stop the live tail
+        function stopStream() {
+            if (eventSource) {
+                eventSource.close();
+                eventSource = undefined;
+            }
+        }
+
+        // Function to start the live tail with the selected filters, after the change liveSeq
+        function startStream() {
+            stopStream();
+            if (paused) {
+                setLiveStatus('Paused');
+                return;
+            }
+            
+            const params = new URLSearchParams(buildQuery(''));
+            params.delete('limit');
+            if (liveSeq) {
+                params.set('since', liveSeq);
+            }
+            
+            // EventSource reconnects by itself, continuing after the last event received
+            setLiveStatus('Connecting...');
+            eventSource = new EventSource('/dashboard/api/logs/stream?' + params.toString());
+            eventSource.onopen = () => setLiveStatus('Live', 'live');
+            eventSource.onerror = () => setLiveStatus('Reconnecting...', 'offline');
+            ['request', 'response'].forEach(type => {
+                eventSource.addEventListener(type, event => {
+                    const log = JSON.parse(event.data);
+                    liveSeq = log.seq;
+                    showLiveEntry(log);
+                });
+            });
+        }
+
+        // Function to show an entry received from the live tail, replacing the entry
+        // of the same request or adding it at the top
+        function showLiveEntry(log) {
+            const logsContainer = document.getElementById('logs-container');
+            const existing = entryElements.get(log.request_id);
+            const logEntry = renderLogEntry(log);
+            
+            if (existing && existing.parentNode === logsContainer) {
+                existing.replaceWith(logEntry);
+                return;
+            }
+            
+            if (!logsContainer.querySelector('.log-entry')) {
+                logsContainer.innerHTML = '';
+            }
+            logsContainer.prepend(logEntry);
+            
+            // Drop the oldest entries, they can be loaded again as next page
+            const entries = logsContainer.querySelectorAll('.log-entry');
+            if (entries.length > maxEntries) {
+                for (let i = maxEntries; i < entries.length; i++) {
+                    const dropped = entries[i];
+                    if (entryElements.get(dropped.dataset.requestId) === dropped) {
+                        entryElements.delete(dropped.dataset.requestId);
+                    }
+                    dropped.remove();
+                }
+                nextCursor = entries[maxEntries - 1].dataset.id;
+                document.getElementById('load-more').style.display = 'block';
+            }
+        }
+
+        // Function to pause or resume the live tail
+        function toggleLive() {
+            paused = !paused;
+            document.getElementById('live-btn').textContent = paused ? 'Resume' : 'Pause';
+            if (paused) {
+                stopStream();
+                setLiveStatus('Paused');
+            } else {
+                startStream();
+            }
+        }
+
+        // Function to reset the filters
+        function clearFilters() {
+            document.querySelectorAll('#filters input, #filters select').forEach(input => {
+                if (input.type === 'checkbox') {
+                    input.checked = false;
+                } else {
+                    input.value = '';
+                }
+            });
+            fetchLogs();
+        }
+
         // Set up event listeners
         document.addEventListener('DOMContentLoaded', function() {
             // Initial fetch
@@ -398,21 +599,23 @@ const logsHTMLTemplate = `
             // Limit change
             document.getElementById('limit').addEventListener('change', fetchLogs);
             
-            // Auto refresh
-            const autoRefreshCheckbox = document.getElementById('auto-refresh');
-            const refreshIntervalSelect = document.getElementById('refresh-interval');
+            // Filters, text inputs apply on Enter
+            document.getElementById('apply-filters-btn').addEventListener('click', fetchLogs);
+            document.getElementById('clear-filters-btn').addEventListener('click', clearFilters);
+            document.querySelectorAll('#filters input').forEach(input => {
+                input.addEventListener('keydown', event => {
+                    if (event.key === 'Enter') fetchLogs();
+                });
+            });
+            document.querySelectorAll('#filters select, #filter-errors').forEach(input => {
+                input.addEventListener('change', fetchLogs);
+            });
             
-            function updateAutoRefresh() {
-                clearInterval(autoRefreshInterval);
-                
-                if (autoRefreshCheckbox.checked) {
-                    const interval = parseInt(refreshIntervalSelect.value) * 1000;
-                    autoRefreshInterval = setInterval(fetchLogs, interval);
-                }
-            }
+            // Next page
+            document.getElementById('load-more').addEventListener('click', () => fetchLogs(true));
             
-            autoRefreshCheckbox.addEventListener('change', updateAutoRefresh);
-            refreshIntervalSelect.addEventListener('change', updateAutoRefresh);
+            // Live tail
+            document.getElementById('live-btn').addEventListener('click', toggleLive);
         });
     </script>
 </body>
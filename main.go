@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"os"
 	"os/signal"
 	"sync"
@@ -17,14 +18,20 @@ func main() {
 	// Define command line flags
 	var proxyOnly bool
 	var dashboardOnly bool
+	var configPath string
 
 	flag.BoolVar(&proxyOnly, "proxy-only", false, "Run only the proxy server")
 	flag.BoolVar(&dashboardOnly, "dashboard-only", false, "Run only the dashboard server")
+	flag.StringVar(&configPath, "config", "", "YAML or JSON config file, overlayed by environment variables")
 	flag.Parse()
 
 	// Load configuration
-	proxyConfig := LoadConfigFromEnv()
-	dashboardConfig := LoadDashboardConfigFromEnv()
+	proxyConfig, dashboardConfig, err := LoadConfig(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	reloader := newConfigReloader(configPath, proxyConfig)
 
 	// Configure zerolog
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
@@ -43,7 +50,6 @@ func main() {
 
 	// Initialize DB logger if enabled
 	var dbLogger *DBLogger
-	var err error
 
 	dbLogPath := proxyConfig.DBLogPath
 	dbMaxEntries := proxyConfig.DBMaxEntries
@@ -86,12 +92,14 @@ func main() {
 			Str("logLevel", dashboardConfig.LogLevel.String()).
 			Msg("Starting Dashboard server only")
 
-		runDashboardOnly(dashboardConfig, dbLogger, shutdownCh)
+		runDashboardOnly(dashboardConfig, dbLogger, reloader, shutdownCh)
 	} else if proxyOnly {
 		// Proxy-only mode
 		log.Info().
 			Str("redisAddr", proxyConfig.RedisAddr).
 			Int("redisPoolSize", proxyConfig.RedisPoolSize).
+			Str("transport", proxyConfig.Transport).
+			Str("instanceID", proxyConfig.InstanceID).
 			Int("proxyPort", proxyConfig.Port).
 			Str("fixedTopic", proxyConfig.FixedTopic).
 			Int("respondImmediately", proxyConfig.RespondImmediatelyStatus).
@@ -100,12 +108,14 @@ func main() {
 			Str("logLevel", proxyConfig.LogLevel.String()).
 			Msg("Starting Redis proxy server only")
 
-		runProxyOnly(proxyConfig, dbLogger, shutdownCh, &wg)
+		runProxyOnly(proxyConfig, dbLogger, reloader, shutdownCh, &wg)
 	} else {
 		// Default: run both servers
 		log.Info().
 			Str("redisAddr", proxyConfig.RedisAddr).
 			Int("redisPoolSize", proxyConfig.RedisPoolSize).
+			Str("transport", proxyConfig.Transport).
+			Str("instanceID", proxyConfig.InstanceID).
 			Int("proxyPort", proxyConfig.Port).
 			Int("dashboardPort", dashboardConfig.Port).
 			Str("fixedTopic", proxyConfig.FixedTopic).
@@ -117,7 +127,7 @@ func main() {
 			Int("dbMaxEntries", dbMaxEntries).
 			Msg("Starting Redis proxy and Dashboard servers")
 
-		runBothServers(proxyConfig, dashboardConfig, dbLogger, shutdownCh, &wg)
+		runBothServers(proxyConfig, dashboardConfig, dbLogger, reloader, shutdownCh, &wg)
 	}
 
 	// Wait for all goroutines to complete
@@ -126,10 +136,15 @@ func main() {
 }
 
 // runDashboardOnly runs just the dashboard server
-func runDashboardOnly(config DashboardConfig, dbLogger *DBLogger, shutdownCh chan os.Signal) {
+func runDashboardOnly(config DashboardConfig, dbLogger *DBLogger, reloader *configReloader, shutdownCh chan os.Signal) {
 	// Create and start the dashboard server
 	dashboardServer := NewDashboardServer(config, dbLogger)
 
+	// Reload the log level on configuration changes
+	reloadCtx, stopReload := context.WithCancel(context.Background())
+	defer stopReload()
+	go reloader.Run(reloadCtx, nil)
+
 	// Start in a goroutine for signal handling
 	serverErrCh := make(chan error, 1)
 	go func() {
@@ -155,16 +170,28 @@ func runDashboardOnly(config DashboardConfig, dbLogger *DBLogger, shutdownCh cha
 }
 
 // runProxyOnly runs just the proxy server
-func runProxyOnly(config Config, dbLogger *DBLogger, shutdownCh chan os.Signal, wg *sync.WaitGroup) {
-	// Set up Redis manager
-	redisManager, err := NewRedisManager(config)
+func runProxyOnly(config Config, dbLogger *DBLogger, reloader *configReloader, shutdownCh chan os.Signal, wg *sync.WaitGroup) {
+	// Export the spans of proxied requests
+	shutdownTracing, err := setupTracing(config)
+	if err != nil {
+		log.Fatal().Err(err).Str("exporter", config.TracingExporter).Msg("Failed to set up tracing")
+	}
+	defer shutdownTracing()
+
+	// Connect to the broker
+	broker, err := NewBroker(config)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to connect to Redis")
+		log.Fatal().Err(err).Str("broker", config.Broker).Msg("Failed to connect to broker")
 	}
-	defer redisManager.Close()
+	defer broker.Close()
 
 	// Create and start the proxy server
-	proxyServer := NewProxyServer(config, redisManager, wg, dbLogger)
+	proxyServer := NewProxyServer(config, broker, wg, dbLogger)
+
+	// Apply configuration changes without a restart
+	reloadCtx, stopReload := context.WithCancel(context.Background())
+	defer stopReload()
+	go reloader.Run(reloadCtx, proxyServer)
 
 	// Start in a goroutine for signal handling
 	serverErrCh := make(chan error, 1)
@@ -191,18 +218,31 @@ func runProxyOnly(config Config, dbLogger *DBLogger, shutdownCh chan os.Signal,
 }
 
 // runBothServers runs both the proxy and dashboard servers
-func runBothServers(proxyConfig Config, dashboardConfig DashboardConfig, dbLogger *DBLogger, shutdownCh chan os.Signal, wg *sync.WaitGroup) {
-	// Set up Redis manager
-	redisManager, err := NewRedisManager(proxyConfig)
+func runBothServers(proxyConfig Config, dashboardConfig DashboardConfig, dbLogger *DBLogger, reloader *configReloader,
+	shutdownCh chan os.Signal, wg *sync.WaitGroup) {
+	// Export the spans of proxied requests
+	shutdownTracing, err := setupTracing(proxyConfig)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to connect to Redis")
+		log.Fatal().Err(err).Str("exporter", proxyConfig.TracingExporter).Msg("Failed to set up tracing")
 	}
-	defer redisManager.Close()
+	defer shutdownTracing()
+
+	// Connect to the broker
+	broker, err := NewBroker(proxyConfig)
+	if err != nil {
+		log.Fatal().Err(err).Str("broker", proxyConfig.Broker).Msg("Failed to connect to broker")
+	}
+	defer broker.Close()
 
 	// Create servers
-	proxyServer := NewProxyServer(proxyConfig, redisManager, wg, dbLogger)
+	proxyServer := NewProxyServer(proxyConfig, broker, wg, dbLogger)
 	dashboardServer := NewDashboardServer(dashboardConfig, dbLogger)
 
+	// Apply configuration changes without a restart
+	reloadCtx, stopReload := context.WithCancel(context.Background())
+	defer stopReload()
+	go reloader.Run(reloadCtx, proxyServer)
+
 	// Start servers in separate goroutines
 	proxyErrCh := make(chan error, 1)
 	dashboardErrCh := make(chan error, 1)
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// MemoryHandler handles a message published to a topic of the memory broker
+type MemoryHandler func(topic string, message []byte)
+
+// MemoryBroker is an in-process broker. Messages are passed to handlers subscribed
+// in the same process, such as the built-in echo backend, so the proxy can run and
+// be tested without Redis. Nothing is shared between proxy instances.
+type MemoryBroker struct {
+	prefix  string // <reply prefix>:<instance ID>
+	replies *replyRegistry
+	logger  zerolog.Logger
+
+	mutex         sync.RWMutex
+	subscriptions map[int]memorySubscription
+	nextID        int
+	values        map[string]memoryValue
+	closed        bool
+}
+
+// memorySubscription is a handler for the topics matching a pattern
+type memorySubscription struct {
+	pattern string
+	handler MemoryHandler
+}
+
+// memoryValue is a stored value with its expiry
+type memoryValue struct {
+	data    []byte
+	expires time.Time
+}
+
+// NewMemoryBroker creates an in-process broker without subscribers
+func NewMemoryBroker(config Config) *MemoryBroker {
+	mb := &MemoryBroker{
+		prefix:        fmt.Sprintf("%s:%s", config.ReplyPrefix, config.InstanceID),
+		replies:       newReplyRegistry(),
+		logger:        log.With().Str("component", "memoryBroker").Logger(),
+		subscriptions: make(map[int]memorySubscription),
+		values:        make(map[string]memoryValue),
+	}
+	mb.logger.Info().Str("prefix", mb.prefix).Msg("Memory broker started")
+	return mb
+}
+
+// Subscribe calls the handler in a new goroutine for every message published to a
+// topic matching the pattern (see path.Match, "*" matches every topic). It returns
+// a function that removes the subscription.
+func (mb *MemoryBroker) Subscribe(pattern string, handler MemoryHandler) func() {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	id := mb.nextID
+	mb.nextID++
+	mb.subscriptions[id] = memorySubscription{pattern: pattern, handler: handler}
+
+	return func() {
+		mb.mutex.Lock()
+		delete(mb.subscriptions, id)
+		mb.mutex.Unlock()
+	}
+}
+
+// Publish passes a message to the subscribers of a topic, or to the waiting request
+// if it is a reply topic. ErrNoSubscribers is returned if no handler matches.
+func (mb *MemoryBroker) Publish(ctx context.Context, topic string, message []byte) error {
+	if correlationID, ok := strings.CutPrefix(topic, mb.prefix+":"); ok {
+		mb.replies.deliver(mb.logger, topic, correlationID, string(message))
+		return nil
+	}
+	if mb.deliver(topic, message) == 0 {
+		return ErrNoSubscribers
+	}
+	return nil
+}
+
+// PublishCancel passes a cancellation to the subscribers of the cancel topic
+func (mb *MemoryBroker) PublishCancel(ctx context.Context, cancelTopic string, cancellation []byte) error {
+	mb.deliver(cancelTopic, cancellation)
+	return nil
+}
+
+// deliver calls the handlers subscribed to a topic and returns their number
+func (mb *MemoryBroker) deliver(topic string, message []byte) int {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	receivers := 0
+	for _, subscription := range mb.subscriptions {
+		if matched, _ := path.Match(subscription.pattern, topic); !matched {
+			continue
+		}
+		// Handlers get their own copy, the caller may reuse the message
+		go subscription.handler(topic, append([]byte(nil), message...))
+		receivers++
+	}
+	return receivers
+}
+
+// ReplyTopic returns the reply topic for a correlation ID
+func (mb *MemoryBroker) ReplyTopic(correlationID string) string {
+	return mb.prefix + ":" + correlationID
+}
+
+// AwaitReply registers a waiter for the replies to a correlation ID
+func (mb *MemoryBroker) AwaitReply(correlationID string) *ReplyWaiter {
+	return mb.replies.register(mb.ReplyTopic(correlationID), correlationID)
+}
+
+// SetValue stores a value that expires after the TTL, dropping expired values
+func (mb *MemoryBroker) SetValue(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	now := time.Now()
+	for k, v := range mb.values {
+		if now.After(v.expires) {
+			delete(mb.values, k)
+		}
+	}
+	mb.values[key] = memoryValue{data: append([]byte(nil), value...), expires: now.Add(ttl)}
+	return nil
+}
+
+// GetValue returns a stored value, or ErrValueNotFound if it is missing or has expired
+func (mb *MemoryBroker) GetValue(ctx context.Context, key string) ([]byte, error) {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	value, ok := mb.values[key]
+	if !ok || time.Now().After(value.expires) {
+		return nil, ErrValueNotFound
+	}
+	return value.data, nil
+}
+
+// Health returns an error once the broker is closed
+func (mb *MemoryBroker) Health(ctx context.Context) error {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	if mb.closed {
+		return errors.New("memory broker closed")
+	}
+	return nil
+}
+
+// Close removes all subscriptions
+func (mb *MemoryBroker) Close() error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.closed = true
+	clear(mb.subscriptions)
+	return nil
+}
+
+// echo is the built-in backend of the memory broker. Like the sample backend, it
+// replies to every request with the message it received.
+func (mb *MemoryBroker) echo(topic string, message []byte) {
+	var request Message
+	if err := json.Unmarshal(message, &request); err != nil {
+		mb.logger.Warn().Err(err).Str("topic", topic).Msg("Echo backend received invalid message")
+		return
+	}
+
+	// Cancellations and other messages without a reply topic need no reply
+	responseTopic, _ := request.Header["response_topic"].(string)
+	if responseTopic == "" {
+		return
+	}
+
+	reply, err := json.Marshal(Response{
+		Body: map[string]interface{}{
+			"status":           "success",
+			"message":          "Echo response",
+			"original_channel": topic,
+			"original_header":  request.Header,
+			"original_body":    request.Body,
+			"timestamp":        time.Now().Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		mb.logger.Error().Err(err).Msg("Error creating echo response")
+		return
+	}
+	mb.Publish(context.Background(), responseTopic, reply)
+}
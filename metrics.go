@@ -0,0 +1,305 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Topic labels of requests without a topic and of topics beyond METRICS_MAX_TOPICS
+const (
+	metricsNoTopic    = "none"
+	metricsOtherTopic = "other"
+)
+
+// Kinds of timeouts counted by proxy_timeouts_total
+const (
+	timeoutResponse       = "response"
+	timeoutCallback       = "callback"
+	timeoutJob            = "job"
+	timeoutStreamIdle     = "stream_idle"
+	timeoutStreamDuration = "stream_duration"
+)
+
+// requestDurationBuckets cover immediate responses up to long running streams
+var requestDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// proxyMetrics holds the Prometheus metrics of the proxy server. They are kept in
+// a registry of their own, separate from the metrics of the dashboard server.
+type proxyMetrics struct {
+	registry *prometheus.Registry
+
+	requests      *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	inFlight      prometheus.Gauge
+	publishErrors *prometheus.CounterVec
+	timeouts      *prometheus.CounterVec
+	noSubscribers *prometheus.CounterVec
+
+	// Topics seen so far, limited to keep the number of series bounded
+	maxTopics   int
+	topicsMutex sync.Mutex
+	topics      map[string]bool
+}
+
+// newProxyMetrics creates the metrics of a proxy server, including gauges read
+// from its DB logger, WebSocket sessions and Redis connection pool
+func newProxyMetrics(ps *ProxyServer, config Config) *proxyMetrics {
+	m := &proxyMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "proxy_requests_total",
+			Help: "Proxied HTTP requests by topic, method and response status.",
+		}, []string{"topic", "method", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "proxy_request_duration_seconds",
+			Help:    "Time until a proxied HTTP request was answered, including streamed responses.",
+			Buckets: requestDurationBuckets,
+		}, []string{"topic", "method"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "proxy_requests_in_flight",
+			Help: "Proxied HTTP requests currently being handled.",
+		}),
+		publishErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "proxy_publish_errors_total",
+			Help: "Messages that could not be published to the broker.",
+		}, []string{"topic"}),
+		timeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "proxy_timeouts_total",
+			Help: "Replies that did not arrive in time, by kind of request.",
+		}, []string{"topic", "kind"}),
+		noSubscribers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "proxy_no_subscribers_total",
+			Help: "Messages published while no backend was subscribed to the topic.",
+		}, []string{"topic"}),
+		maxTopics: config.MetricsMaxTopics,
+		topics:    make(map[string]bool),
+	}
+
+	m.registry.MustRegister(
+		m.requests, m.duration, m.inFlight, m.publishErrors, m.timeouts, m.noSubscribers,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "proxy_websocket_connections",
+			Help: "Open WebSocket sessions.",
+		}, func() float64 { return float64(ps.websocketConnections.Load()) }),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	if ps.dbLogger != nil && ps.dbLogger.enabled {
+		m.registry.MustRegister(
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name: "proxy_db_logger_queue_depth",
+				Help: "Log entries waiting to be written to the database.",
+			}, func() float64 { return float64(ps.dbLogger.QueueDepth()) }),
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name: "proxy_db_logger_dropped_total",
+				Help: "Log entries dropped because the queue was full.",
+			}, func() float64 { return float64(ps.dbLogger.Dropped()) }),
+		)
+	}
+
+	if rm, ok := ps.broker.(*RedisManager); ok {
+		m.registry.MustRegister(newRedisPoolCollector(rm))
+	}
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *proxyMetrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// topicLabel returns the label of a topic. Once the maximum number of topics is
+// tracked, further topics share a single label.
+func (m *proxyMetrics) topicLabel(topic string) string {
+	if topic == "" {
+		return metricsNoTopic
+	}
+
+	m.topicsMutex.Lock()
+	defer m.topicsMutex.Unlock()
+
+	if m.topics[topic] {
+		return topic
+	}
+	if len(m.topics) >= m.maxTopics {
+		return metricsOtherTopic
+	}
+	m.topics[topic] = true
+	return topic
+}
+
+// methodLabel returns the label of a request method, mapping unknown methods to a single label
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// PublishError counts a message that could not be published
+func (m *proxyMetrics) PublishError(topic string) {
+	m.publishErrors.WithLabelValues(m.topicLabel(topic)).Inc()
+}
+
+// NoSubscribers counts a message that reached no backend
+func (m *proxyMetrics) NoSubscribers(topic string) {
+	m.noSubscribers.WithLabelValues(m.topicLabel(topic)).Inc()
+}
+
+// Timeout counts a reply that did not arrive in time
+func (m *proxyMetrics) Timeout(topic, kind string) {
+	m.timeouts.WithLabelValues(m.topicLabel(topic), kind).Inc()
+}
+
+// StartRequest counts a request as in flight and returns a response writer that
+// records its status. The request is added to the metrics when Finish is called.
+func (m *proxyMetrics) StartRequest(w http.ResponseWriter, r *http.Request) *observedResponse {
+	m.inFlight.Inc()
+	return &observedResponse{ResponseWriter: w, metrics: m, method: methodLabel(r.Method), start: time.Now()}
+}
+
+// observedResponse records the status written for a proxied request. The topic
+// is set by the handler once it is known.
+type observedResponse struct {
+	http.ResponseWriter
+	metrics *proxyMetrics
+	method  string
+	topic   string
+	status  int
+	start   time.Time
+}
+
+// WriteHeader records the status code and writes it to the response
+func (o *observedResponse) WriteHeader(statusCode int) {
+	if o.status == 0 {
+		o.status = statusCode
+	}
+	o.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write writes to the response, with an implicit 200 status if none was written
+func (o *observedResponse) Write(data []byte) (int, error) {
+	if o.status == 0 {
+		o.status = http.StatusOK
+	}
+	return o.ResponseWriter.Write(data)
+}
+
+// Unwrap returns the original response writer, so http.ResponseController can flush it
+func (o *observedResponse) Unwrap() http.ResponseWriter {
+	return o.ResponseWriter
+}
+
+// Status returns the status code of the response, 200 if none was written
+func (o *observedResponse) Status() int {
+	if o.status == 0 {
+		return http.StatusOK
+	}
+	return o.status
+}
+
+// Finish adds the request to the request counter and latency histogram
+func (o *observedResponse) Finish() {
+	o.metrics.inFlight.Dec()
+
+	topic := o.metrics.topicLabel(o.topic)
+	o.metrics.requests.WithLabelValues(topic, o.method, strconv.Itoa(o.Status())).Inc()
+	o.metrics.duration.WithLabelValues(topic, o.method).Observe(time.Since(o.start).Seconds())
+}
+
+// redisPoolCollector exposes the connection pool statistics of the Redis client
+type redisPoolCollector struct {
+	redisManager *RedisManager
+	hits         *prometheus.Desc
+	misses       *prometheus.Desc
+	timeouts     *prometheus.Desc
+	total        *prometheus.Desc
+	idle         *prometheus.Desc
+	stale        *prometheus.Desc
+}
+
+// newRedisPoolCollector creates a collector reading the pool statistics on every scrape
+func newRedisPoolCollector(rm *RedisManager) *redisPoolCollector {
+	return &redisPoolCollector{
+		redisManager: rm,
+		hits:         prometheus.NewDesc("proxy_redis_pool_hits_total", "Times a free connection was found in the pool.", nil, nil),
+		misses:       prometheus.NewDesc("proxy_redis_pool_misses_total", "Times no free connection was found in the pool.", nil, nil),
+		timeouts:     prometheus.NewDesc("proxy_redis_pool_timeouts_total", "Times waiting for a connection from the pool timed out.", nil, nil),
+		total:        prometheus.NewDesc("proxy_redis_pool_connections", "Connections in the pool.", nil, nil),
+		idle:         prometheus.NewDesc("proxy_redis_pool_idle_connections", "Idle connections in the pool.", nil, nil),
+		stale:        prometheus.NewDesc("proxy_redis_pool_stale_connections_total", "Stale connections removed from the pool.", nil, nil),
+	}
+}
+
+// Describe sends the descriptions of the pool metrics
+func (c *redisPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.timeouts
+	ch <- c.total
+	ch <- c.idle
+	ch <- c.stale
+}
+
+// Collect sends the current pool statistics
+func (c *redisPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.redisManager.PoolStats()
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(c.timeouts, prometheus.CounterValue, float64(stats.Timeouts))
+	ch <- prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, float64(stats.TotalConns))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stats.IdleConns))
+	ch <- prometheus.MustNewConstMetric(c.stale, prometheus.CounterValue, float64(stats.StaleConns))
+}
+
+// dashboardMetrics holds the Prometheus metrics of the dashboard server
+type dashboardMetrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// newDashboardMetrics creates the metrics of a dashboard server
+func newDashboardMetrics() *dashboardMetrics {
+	m := &dashboardMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dashboard_http_requests_total",
+			Help: "Dashboard HTTP requests by handler, method and response status.",
+		}, []string{"handler", "method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "dashboard_http_request_duration_seconds",
+			Help:    "Time until a dashboard HTTP request was answered.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"handler"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests, m.duration,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *dashboardMetrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// instrument wraps the handler of a dashboard route to count its requests
+func (m *dashboardMetrics) instrument(route string, handler http.HandlerFunc) http.Handler {
+	labels := prometheus.Labels{"handler": route}
+	return promhttp.InstrumentHandlerDuration(m.duration.MustCurryWith(labels),
+		promhttp.InstrumentHandlerCounter(m.requests.MustCurryWith(labels), handler))
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Status header NATS sends to the reply subject of a message that reached no subscriber
+const (
+	natsStatusHeader = "Status"
+	natsNoResponders = "503"
+)
+
+// errNATSNoKeyValue is returned for job state when JetStream is not available
+var errNATSNoKeyValue = errors.New("job storage requires JetStream, which is not enabled on the NATS server")
+
+// NATSBroker delivers requests over NATS. Topics map to subjects with ":" replaced
+// by ".", and requests carry a reply inbox of this instance, so backends can answer
+// with a native reply or by publishing to the response_topic of the message.
+type NATSBroker struct {
+	conn    *nats.Conn
+	inbox   string // Prefix of the reply subjects of this instance
+	sub     *nats.Subscription
+	replies *replyRegistry
+	kv      nats.KeyValue // Job storage, nil without JetStream
+	logger  zerolog.Logger
+}
+
+// NewNATSBroker connects to NATS and subscribes to the reply inbox of this instance
+func NewNATSBroker(config Config) (*NATSBroker, error) {
+	nb := &NATSBroker{
+		replies: newReplyRegistry(),
+		logger:  log.With().Str("component", "natsBroker").Logger(),
+	}
+
+	options := []nats.Option{
+		nats.Name("async-proxy " + config.InstanceID),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			// Replies published while disconnected are not delivered
+			nb.replies.interrupted()
+			nb.logger.Warn().Err(err).Msg("Disconnected from NATS, reconnecting")
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			nb.logger.Warn().Str("server", conn.ConnectedUrlRedacted()).Int("inFlight", nb.replies.InFlight()).
+				Msg("Reconnected to NATS, replies sent while disconnected are lost")
+		}),
+	}
+	if config.NATSCredsFile != "" {
+		options = append(options, nats.UserCredentials(config.NATSCredsFile))
+	}
+
+	conn, err := nats.Connect(config.NATSURL, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	nb.conn = conn
+	nb.inbox = conn.NewInbox()
+
+	nb.sub, err = conn.Subscribe(nb.inbox+".*", nb.dispatch)
+	if err == nil {
+		// Make sure the subscription is established before accepting requests
+		err = conn.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to subscribe to reply inbox %s: %w", nb.inbox, err)
+	}
+
+	// Job state lives in a key-value bucket whose TTL is the job TTL
+	nb.kv, err = nb.jobBucket(config)
+	if err != nil {
+		nb.logger.Warn().Err(err).Str("bucket", config.NATSJobBucket).
+			Msg("JetStream key-value store unavailable, asynchronous jobs will fail")
+	}
+
+	nb.logger.Info().Str("server", conn.ConnectedUrlRedacted()).Str("inbox", nb.inbox).Msg("Connected to NATS")
+	return nb, nil
+}
+
+// jobBucket binds to the key-value bucket for job state, creating it if needed
+func (nb *NATSBroker) jobBucket(config Config) (nats.KeyValue, error) {
+	js, err := nb.conn.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	kv, err := js.KeyValue(config.NATSJobBucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket:      config.NATSJobBucket,
+			Description: "Asynchronous jobs of the HTTP proxy",
+			TTL:         time.Duration(config.JobTTL) * time.Second,
+		})
+	}
+	return kv, err
+}
+
+// natsSubject converts a topic to a NATS subject
+func natsSubject(topic string) (string, error) {
+	subject := strings.ReplaceAll(topic, ":", ".")
+	for _, token := range strings.Split(subject, ".") {
+		if token == "" || token == "*" || token == ">" || strings.ContainsAny(token, " \t\r\n") {
+			return "", fmt.Errorf("topic %q is not a valid NATS subject", topic)
+		}
+	}
+	return subject, nil
+}
+
+// Publish publishes a message to the subject of a topic, with the response topic
+// of the message as reply subject. NATS answers on the reply subject if no
+// backend is subscribed, which is passed on to the request as a 503 reply.
+func (nb *NATSBroker) Publish(ctx context.Context, topic string, message []byte) error {
+	subject, err := natsSubject(topic)
+	if err != nil {
+		return err
+	}
+
+	var envelope struct {
+		Header struct {
+			ResponseTopic string `json:"response_topic"`
+		} `json:"header"`
+	}
+	json.Unmarshal(message, &envelope)
+
+	return nb.conn.PublishMsg(&nats.Msg{Subject: subject, Reply: envelope.Header.ResponseTopic, Data: message})
+}
+
+// PublishCancel publishes a cancellation to the subject of the cancel topic
+func (nb *NATSBroker) PublishCancel(ctx context.Context, cancelTopic string, cancellation []byte) error {
+	subject, err := natsSubject(cancelTopic)
+	if err != nil {
+		return err
+	}
+	return nb.conn.Publish(subject, cancellation)
+}
+
+// dispatch delivers a reply to the waiter registered for its correlation ID
+func (nb *NATSBroker) dispatch(msg *nats.Msg) {
+	correlationID := msg.Subject[strings.LastIndex(msg.Subject, ".")+1:]
+	payload := string(msg.Data)
+
+	if len(msg.Data) == 0 && msg.Header.Get(natsStatusHeader) == natsNoResponders {
+		reply, _ := json.Marshal(Response{Status: http.StatusServiceUnavailable, Body: "No backend subscribed to topic"})
+		payload = string(reply)
+	}
+	nb.replies.deliver(nb.logger, msg.Subject, correlationID, payload)
+}
+
+// ReplyTopic returns the reply subject for a correlation ID
+func (nb *NATSBroker) ReplyTopic(correlationID string) string {
+	return nb.inbox + "." + correlationID
+}
+
+// AwaitReply registers a waiter for the replies to a correlation ID
+func (nb *NATSBroker) AwaitReply(correlationID string) *ReplyWaiter {
+	return nb.replies.register(nb.ReplyTopic(correlationID), correlationID)
+}
+
+// SetValue stores a value in the job bucket. Values expire with the TTL of the
+// bucket, which is set to JOB_TTL when the bucket is created.
+func (nb *NATSBroker) SetValue(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if nb.kv == nil {
+		return errNATSNoKeyValue
+	}
+	_, err := nb.kv.Put(strings.ReplaceAll(key, ":", "."), value)
+	return err
+}
+
+// GetValue returns a value from the job bucket, or ErrValueNotFound
+func (nb *NATSBroker) GetValue(ctx context.Context, key string) ([]byte, error) {
+	if nb.kv == nil {
+		return nil, errNATSNoKeyValue
+	}
+	entry, err := nb.kv.Get(strings.ReplaceAll(key, ":", "."))
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil, ErrValueNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return entry.Value(), nil
+}
+
+// Health checks the connection to NATS with a round trip to the server
+func (nb *NATSBroker) Health(ctx context.Context) error {
+	if !nb.conn.IsConnected() {
+		return fmt.Errorf("nats connection %s", nb.conn.Status())
+	}
+	if !nb.sub.IsValid() {
+		return errors.New("reply subscription closed")
+	}
+	return nb.conn.FlushWithContext(ctx)
+}
+
+// Close unsubscribes from the reply inbox and closes the connection
+func (nb *NATSBroker) Close() error {
+	nb.sub.Unsubscribe()
+	nb.conn.Close()
+	return nil
+}
@@ -3,33 +3,65 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// noSubscriberRetryInterval is the delay between publish attempts while no backend is subscribed
+const noSubscriberRetryInterval = 100 * time.Millisecond
+
 // ProxyServer represents the HTTP server for Redis proxy
 type ProxyServer struct {
-	config       Config
-	redisManager *RedisManager
-	server       *http.Server
-	wg           *sync.WaitGroup
-	dbLogger     *DBLogger // Optional DB logger for request/response tracking
+	config   atomic.Pointer[Config] // Swapped on configuration reload, read with cfg()
+	broker   Broker
+	server   *http.Server
+	wg       *sync.WaitGroup
+	dbLogger *DBLogger // Optional DB logger for request/response tracking
+	metrics  *proxyMetrics
+
+	// Asynchronous jobs and callbacks waiting for replies in the background
+	jobStore         *JobStore
+	callbackClient   *http.Client
+	backgroundWG     sync.WaitGroup
+	backgroundCtx    context.Context
+	backgroundCancel context.CancelFunc
+
+	// WebSocket sessions, closed when the server shuts down
+	upgrader             *websocket.Upgrader
+	websocketConnections atomic.Int64
+	websocketCtx         context.Context
+	websocketCancel      context.CancelFunc
 }
 
 // NewProxyServer creates a new proxy server
-func NewProxyServer(config Config, redisManager *RedisManager, wg *sync.WaitGroup, dbLogger *DBLogger) *ProxyServer {
+func NewProxyServer(config Config, broker Broker, wg *sync.WaitGroup, dbLogger *DBLogger) *ProxyServer {
 	proxy := &ProxyServer{
-		config:       config,
-		redisManager: redisManager,
-		wg:           wg,
-		dbLogger:     dbLogger,
+		broker:   broker,
+		wg:       wg,
+		dbLogger: dbLogger,
+		jobStore: NewJobStore(broker, config),
+		callbackClient: &http.Client{
+			Timeout: time.Duration(config.CallbackRequestTimeout) * time.Second,
+		},
 	}
+	proxy.config.Store(&config)
+	proxy.metrics = newProxyMetrics(proxy, config)
+	proxy.backgroundCtx, proxy.backgroundCancel = context.WithCancel(context.Background())
+	proxy.websocketCtx, proxy.websocketCancel = context.WithCancel(context.Background())
 
 	// Create HTTP server with proper timeouts
 	mux := http.NewServeMux()
@@ -37,6 +69,31 @@ func NewProxyServer(config Config, redisManager *RedisManager, wg *sync.WaitGrou
 	// Main request handler
 	mux.HandleFunc("/", proxy.handleRequest)
 
+	// Status of asynchronous jobs
+	mux.HandleFunc(config.JobsPath, proxy.handleJobStatus)
+
+	// Health of the broker connection
+	if config.HealthPath != "" {
+		mux.HandleFunc(config.HealthPath, proxy.handleHealth)
+	}
+
+	// Prometheus metrics
+	if config.MetricsPath != "" {
+		mux.Handle(config.MetricsPath, proxy.metrics.Handler())
+	}
+
+	// WebSocket bridge
+	if config.WebSocketPath != "" {
+		proxy.upgrader = newWebSocketUpgrader(config)
+		mux.HandleFunc(config.WebSocketPath, proxy.handleWebSocket)
+
+		if dbLogger != nil && dbLogger.enabled {
+			if err := dbLogger.CloseStaleWebSocketSessions(); err != nil {
+				log.Error().Err(err).Msg("Failed to close stale WebSocket sessions")
+			}
+		}
+	}
+
 	// The /logs and /stats endpoints are moved to the dashboard server
 
 	proxy.server = &http.Server{
@@ -48,18 +105,42 @@ func NewProxyServer(config Config, redisManager *RedisManager, wg *sync.WaitGrou
 		MaxHeaderBytes: config.MaxHeaderBytes,
 	}
 
+	// Hijacked WebSocket connections are not closed by Shutdown itself
+	proxy.server.RegisterOnShutdown(proxy.websocketCancel)
+
 	return proxy
 }
 
+// cfg returns the current configuration
+func (ps *ProxyServer) cfg() *Config {
+	return ps.config.Load()
+}
+
 // Start starts the HTTP server
 func (ps *ProxyServer) Start() error {
-	log.Info().Int("port", ps.config.Port).Msg("Starting HTTP proxy server")
+	log.Info().Int("port", ps.cfg().Port).Msg("Starting HTTP proxy server")
 	return ps.server.ListenAndServe()
 }
 
 // Shutdown gracefully shuts down the server
 func (ps *ProxyServer) Shutdown(ctx context.Context) error {
-	return ps.server.Shutdown(ctx)
+	err := ps.server.Shutdown(ctx)
+
+	// Give pending jobs and callbacks until the shutdown deadline to finish
+	backgroundDone := make(chan struct{})
+	go func() {
+		ps.backgroundWG.Wait()
+		close(backgroundDone)
+	}()
+
+	select {
+	case <-backgroundDone:
+	case <-ctx.Done():
+		ps.backgroundCancel()
+		<-backgroundDone
+	}
+
+	return err
 }
 
 // handleRequest processes incoming HTTP requests
@@ -68,15 +149,31 @@ func (ps *ProxyServer) handleRequest(w http.ResponseWriter, r *http.Request) {
 	ps.wg.Add(1)
 	defer ps.wg.Done()
 
-	ctx := context.Background()
+	// Record the request in the metrics once it is answered
+	observed := ps.metrics.StartRequest(w, r)
+	defer observed.Finish()
+	w = observed
+
+	// Continue the trace of the client, if it sent a traceparent header. The request
+	// context is done when the client disconnects.
+	ctx, span := tracer.Start(extractRequestTraceContext(r), "HTTP "+r.Method, trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(semconv.HTTPRequestMethodKey.String(r.Method), semconv.URLPath(r.URL.Path)))
+	defer func() { endRequestSpan(span, observed.Status()) }()
+
 	requestID := uuid.New().String()
-	logger := log.With().Str("requestID", requestID).Str("path", r.URL.Path).Str("method", r.Method).Logger()
+	logContext := log.With().Str("requestID", requestID).Str("path", r.URL.Path).Str("method", r.Method)
+	if span.SpanContext().HasTraceID() {
+		logContext = logContext.Str("traceID", span.SpanContext().TraceID().String())
+	}
+	logger := logContext.Logger()
 
 	startTime := time.Now()
 	logger.Debug().Msg("Received request")
 
 	// Read request body with size limit
+	_, readSpan := tracer.Start(ctx, "read body")
 	body, err := io.ReadAll(io.LimitReader(r.Body, 10<<20)) // 10MB limit
+	endSpan(readSpan, err)
 	if err != nil {
 		logger.Error().Err(err).Msg("Error reading request body")
 		http.Error(w, "Error reading request body", http.StatusBadRequest)
@@ -87,28 +184,7 @@ func (ps *ProxyServer) handleRequest(w http.ResponseWriter, r *http.Request) {
 	logger.Debug().Int("bodyLength", len(body)).Msg("Request body read")
 
 	// Prepare headers map
-	headers := make(map[string]interface{})
-	for key, values := range r.Header {
-		if len(values) == 1 {
-			headers[key] = values[0]
-		} else {
-			headers[key] = values
-		}
-	}
-
-	// Add query parameters to headers
-	queryParams := r.URL.Query()
-	for key, values := range queryParams {
-		if len(values) == 1 {
-			headers["query_"+key] = values[0]
-		} else {
-			headers["query_"+key] = values
-		}
-	}
-
-	// Add path and method to headers
-	headers["path"] = r.URL.Path
-	headers["method"] = r.Method
+	headers := requestHeaders(r)
 	headers["request_id"] = requestID
 
 	// Create message
@@ -128,32 +204,61 @@ func (ps *ProxyServer) handleRequest(w http.ResponseWriter, r *http.Request) {
 		Body:   bodyData,
 	}
 
-	// Determine the topic to publish to
-	var topic string
-	if ps.config.FixedTopic != "" {
-		topic = ps.config.FixedTopic
-		logger.Debug().Str("fixedTopic", topic).Msg("Using fixed topic")
+	// Determine the topic to publish to and how to handle the request
+	plan := ps.planRequest(r)
+	if plan == nil {
+		err := fmt.Errorf("no route for %s %s", r.Method, r.URL.Path)
+		logger.Warn().Int("status", ps.cfg().UnmatchedRouteStatus).Msg("No matching route")
+		ps.rejectRequest(w, r, requestID, "", startTime, ps.cfg().UnmatchedRouteStatus, err)
+		return
+	}
+	topic := plan.topic
+	observed.topic = topic
+
+	// Verify the caller before anything is published
+	if auth := ps.cfg().Auth; auth != nil {
+		identity, status, err := auth.Authenticate(r, body, plan.routeName(), topic)
+		if err != nil {
+			logger.Warn().Err(err).Int("status", status).Str("topic", topic).Msg("Request rejected")
+			if status == http.StatusUnauthorized && auth.JWT != nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			ps.rejectRequest(w, r, requestID, topic, startTime, status, err)
+			return
+		}
+		auth.forward(message.Header, identity)
+	}
+
+	if plan.route != nil {
+		logger.Debug().Str("route", plan.route.Route.Path).Str("topic", topic).Msg("Using route topic")
+		message.Header["path_params"] = plan.route.Params
+		if plan.route.Route.Name != "" {
+			message.Header["route"] = plan.route.Route.Name
+		}
 	} else {
-		// Use the path but replace slashes with Redis separator
-		topic = createTopicFromPath(r.URL.Path)
-		logger.Debug().Str("pathBasedTopic", topic).Msg("Using path-based topic")
+		logger.Debug().Str("topic", topic).Msg("Using topic")
 	}
 
-	// Generate a unique response topic
+	// Asynchronous jobs use the request ID as job ID and reply correlation ID
+	asyncJob := plan.asyncJob
+
+	// Generate a unique response topic on the shared reply subscription
 	responseID := uuid.New().String()
-	responseTopic := fmt.Sprintf("%s:response:%s", topic, responseID)
+	if asyncJob {
+		responseID = requestID
+		message.Header["job_id"] = requestID
+	}
+	responseTopic := ps.broker.ReplyTopic(responseID)
 
 	logger.Debug().Str("responseTopic", responseTopic).Msg("Created response topic")
 
 	// Add the response topic to the message headers
 	message.Header["response_topic"] = responseTopic
 
-	// Marshal the message to JSON
-	messageJSON, err := json.Marshal(message)
-	if err != nil {
-		logger.Error().Err(err).Msg("Error creating message")
-		http.Error(w, "Error creating message", http.StatusInternalServerError)
-		return
+	// Tell backends until when the reply is awaited, so they can abandon stale work
+	if plan.timeout > 0 {
+		deadline := startTime.Add(time.Duration(plan.timeout) * time.Second)
+		message.Header["deadline"] = deadline.UTC().Format(time.RFC3339Nano)
 	}
 
 	// Log request to database if enabled
@@ -161,152 +266,163 @@ func (ps *ProxyServer) handleRequest(w http.ResponseWriter, r *http.Request) {
 		ps.dbLogger.LogRequest(ctx, requestID, r.Method, r.URL.Path, topic, responseTopic, bodyData)
 	}
 
-	// If configured to respond immediately, do so and return
-	if ps.config.RespondImmediatelyStatus > 0 {
-		logger.Debug().Str("topic", topic).Msg("Publishing message")
+	// Handle asynchronous jobs, replies are stored for polling
+	if asyncJob {
+		job := &Job{
+			ID:        requestID,
+			State:     JobPending,
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Topic:     topic,
+			CreatedAt: startTime,
+		}
+		ps.startJob(w, r, logger, job, message, plan.timeout, startTime)
+		return
+	}
 
-		// Publish the message to Redis
-		err = ps.redisManager.Publish(ctx, topic, messageJSON)
+	// If configured to respond immediately, do so and return
+	if plan.immediateStatus > 0 {
+		// Optionally deliver the reply to a callback URL in the background
+		callbackURL, err := ps.callbackURL(r)
 		if err != nil {
-			logger.Error().Err(err).Str("topic", topic).Msg("Error publishing to Redis")
+			logger.Warn().Err(err).Msg("Rejecting request with invalid callback URL")
 
-			// Log error response
 			if ps.dbLogger != nil && ps.dbLogger.enabled {
-				ps.dbLogger.LogResponse(requestID, http.StatusInternalServerError, nil, time.Since(startTime), err)
+				ps.dbLogger.LogResponse(requestID, http.StatusBadRequest, nil, time.Since(startTime), err)
 			}
 
-			http.Error(w, "Error publishing to Redis", http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		logger.Debug().Int("statusCode", ps.config.RespondImmediatelyStatus).Msg("Responding immediately")
-
-		// Log success response
-		if ps.dbLogger != nil && ps.dbLogger.enabled {
-			ps.dbLogger.LogResponse(requestID, ps.config.RespondImmediatelyStatus, nil, time.Since(startTime), nil)
+		var waiter *ReplyWaiter
+		if callbackURL != "" {
+			// Register for the reply BEFORE publishing the message
+			waiter = ps.awaitReply(ctx, responseID)
 		}
 
-		w.WriteHeader(ps.config.RespondImmediatelyStatus)
-		return
-	}
-
-	// At this point, we know we need to wait for a response
-	logger.Debug().Str("responseTopic", responseTopic).Msg("Setting up response handler")
+		logger.Debug().Str("topic", topic).Msg("Publishing message")
 
-	// Create a timeout context
-	timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(ps.config.ResponseTimeout)*time.Second)
-	defer cancel()
+		// Publish the message to Redis
+		if err := ps.publish(ctx, logger, topic, message); err != nil {
+			if waiter != nil {
+				waiter.Close()
+			}
+			ps.handlePublishError(w, logger, requestID, topic, startTime, err)
+			return
+		}
 
-	// Subscribe to the response topic BEFORE publishing the message
-	pubsub := ps.redisManager.Subscribe(ctx, responseTopic)
-	defer pubsub.Close()
+		if waiter != nil {
+			logger.Debug().Str("callbackURL", callbackURL).Msg("Delivering reply to callback URL")
+			ps.backgroundWG.Add(1)
+			go func() {
+				defer ps.backgroundWG.Done()
+				defer waiter.Close()
+				ps.deliverCallback(logger, requestID, topic, callbackURL, plan.timeout, waiter)
+			}()
+		}
 
-	// Make sure subscription is established before waiting for messages
-	if _, err := pubsub.Receive(ctx); err != nil {
-		logger.Error().Err(err).Str("responseTopic", responseTopic).Msg("Error establishing subscription")
+		logger.Debug().Int("statusCode", plan.immediateStatus).Msg("Responding immediately")
 
-		// Log error response
+		// Log success response
 		if ps.dbLogger != nil && ps.dbLogger.enabled {
-			ps.dbLogger.LogResponse(requestID, http.StatusInternalServerError, nil, time.Since(startTime), err)
+			ps.dbLogger.LogResponse(requestID, plan.immediateStatus, nil, time.Since(startTime), nil)
 		}
 
-		http.Error(w, "Error connecting to response channel", http.StatusInternalServerError)
+		w.WriteHeader(plan.immediateStatus)
 		return
 	}
 
-	logger.Debug().Str("responseTopic", responseTopic).Msg("Subscription established")
+	// At this point, we know we need to wait for a response
+	logger.Debug().Str("responseTopic", responseTopic).Msg("Setting up response handler")
 
-	// Set up channels for message handling
-	msgChan := make(chan *json.RawMessage)
-	errChan := make(chan error)
+	// Create a timeout context
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(plan.timeout)*time.Second)
+	defer cancel()
 
-	// Start the goroutine to listen for messages BEFORE publishing
-	go func() {
-		ch := pubsub.Channel()
-		logger.Debug().Msg("Started goroutine to listen for messages")
+	// Register for the reply BEFORE publishing the message
+	waiter := ps.awaitReply(ctx, responseID)
+	defer waiter.Close()
 
-		select {
-		case msg := <-ch:
-			logger.Debug().Str("channel", msg.Channel).Msg("Received message from channel")
-			var rawMsg json.RawMessage = []byte(msg.Payload)
-			msgChan <- &rawMsg
-		case <-timeoutCtx.Done():
-			errChan <- timeoutCtx.Err()
-		}
-	}()
+	logger.Debug().Str("responseTopic", responseTopic).Msg("Reply waiter registered")
 
-	// NOW publish the message to Redis after the listener is set up
+	// NOW publish the message to Redis after the waiter is registered
 	logger.Debug().Str("topic", topic).Msg("Publishing message")
-	err = ps.redisManager.Publish(ctx, topic, messageJSON)
-	if err != nil {
-		logger.Error().Err(err).Str("topic", topic).Msg("Error publishing to Redis")
-
-		// Log error response
-		if ps.dbLogger != nil && ps.dbLogger.enabled {
-			ps.dbLogger.LogResponse(requestID, http.StatusInternalServerError, nil, time.Since(startTime), err)
-		}
-
-		http.Error(w, "Error publishing to Redis", http.StatusInternalServerError)
+	if err := ps.publish(ctx, logger, topic, message); err != nil {
+		ps.handlePublishError(w, logger, requestID, topic, startTime, err)
 		return
 	}
 
 	logger.Debug().Msg("Message published successfully")
-	logger.Debug().Str("responseTopic", responseTopic).Int("timeout", ps.config.ResponseTimeout).
+	logger.Debug().Str("responseTopic", responseTopic).Int("timeout", plan.timeout).
 		Msg("Waiting for response")
+	_, waitSpan := tracer.Start(ctx, "receive reply", trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(semconv.MessagingDestinationName(responseTopic), semconv.MessagingOperationTypeReceive))
 
 	// Wait for either a message, an error, or a timeout
-	var responseBody interface{}
+	var response *Response
 	var statusCode int
 	var responseErr error
 
 	select {
-	case msg := <-msgChan:
-		logger.Debug().Str("payload", truncateString(string(*msg), 200)).
+	case payload := <-waiter.Replies():
+		logger.Debug().Str("payload", truncateString(payload, 200)).
 			Msg("Processing received message")
 
-		// Extract response body with more flexible handling
-		responseBody, err = extractResponseBody(string(*msg))
+		// A reply carrying a sequence number starts a streamed response
+		if chunk, ok := parseStreamChunk(payload); ok {
+			linkReplyTraceContext(waitSpan, chunk.Headers)
+			waitSpan.End()
+			ps.streamResponse(w, r, logger, requestID, topic, chunk, waiter, startTime)
+			return
+		}
+
+		// Parse the reply, accepting both response envelopes and legacy bodies
+		response, err = parseResponse(payload)
 		if err != nil {
-			logger.Error().Err(err).Str("payload", truncateString(string(*msg), 500)).
+			logger.Error().Err(err).Str("payload", truncateString(payload, 500)).
 				Msg("Error parsing response")
 
 			statusCode = http.StatusInternalServerError
 			responseErr = fmt.Errorf("error parsing response: %w", err)
 		} else {
-			logger.Debug().Msg("Response processed successfully")
-			statusCode = http.StatusOK
+			logger.Debug().Int("status", response.Status).Msg("Response processed successfully")
+			statusCode = response.Status
+			linkReplyTraceContext(waitSpan, response.Headers)
 		}
 
-	case err := <-errChan:
-		logger.Error().Err(err).Msg("Error receiving message")
-		statusCode = http.StatusInternalServerError
-		responseErr = fmt.Errorf("error receiving response: %w", err)
-
 	case <-timeoutCtx.Done():
-		// Timeout occurred
-		logger.Error().Int("timeout", ps.config.ResponseTimeout).Msg("Response timeout")
-		statusCode = http.StatusGatewayTimeout
-		responseErr = fmt.Errorf("response timeout after %d seconds", ps.config.ResponseTimeout)
-	}
-
-	// Log response to database if enabled
-	if ps.dbLogger != nil && ps.dbLogger.enabled {
-		ps.dbLogger.LogResponse(requestID, statusCode, responseBody, time.Since(startTime), responseErr)
+		if ctx.Err() != nil {
+			// The client disconnected
+			logger.Warn().Msg("Client closed request before the response arrived")
+			statusCode = StatusClientClosedRequest
+			responseErr = errClientClosedRequest
+		} else {
+			// Timeout occurred
+			logger.Error().Int("timeout", plan.timeout).Msg("Response timeout")
+			statusCode = http.StatusGatewayTimeout
+			responseErr = replyTimeoutError(plan.timeout, waiter)
+			ps.metrics.Timeout(topic, timeoutResponse)
+		}
+		ps.cancelRequest(logger, topic, requestID, responseErr)
 	}
+	endSpan(waitSpan, responseErr)
 
 	// Handle error cases
 	if responseErr != nil {
+		if ps.dbLogger != nil && ps.dbLogger.enabled {
+			ps.dbLogger.LogResponse(requestID, statusCode, nil, time.Since(startTime), responseErr)
+		}
+
 		http.Error(w, responseErr.Error(), statusCode)
 		return
 	}
 
-	// Send the response body back to the client
-	w.Header().Set("Content-Type", "application/json")
-	responseJSON, err := json.Marshal(responseBody)
+	// Encode the response body before committing to a status code
+	contentType, responseData, err := encodeResponseBody(response)
 	if err != nil {
 		logger.Error().Err(err).Msg("Error formatting response")
 
-		// Update response log with error
 		if ps.dbLogger != nil && ps.dbLogger.enabled {
 			ps.dbLogger.LogResponse(requestID, http.StatusInternalServerError, nil, time.Since(startTime), err)
 		}
@@ -315,7 +431,224 @@ func (ps *ProxyServer) handleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Log response to database if enabled
+	if ps.dbLogger != nil && ps.dbLogger.enabled {
+		ps.dbLogger.LogResponse(requestID, response.Status, response.Body, time.Since(startTime), nil)
+	}
+
+	// Send the response back to the client
 	logger.Debug().Msg("Writing response to client")
-	w.Write(responseJSON)
+	writeResponse(w, response, contentType, responseData)
 	logger.Debug().Msg("Response sent to client successfully")
 }
+
+// rejectRequest answers a request that is not published, such as a request matching
+// no route or without valid credentials, and records the rejection in the request log
+func (ps *ProxyServer) rejectRequest(w http.ResponseWriter, r *http.Request, requestID, topic string, startTime time.Time, status int, err error) {
+	if ps.dbLogger != nil && ps.dbLogger.enabled {
+		ps.dbLogger.LogRequest(r.Context(), requestID, r.Method, r.URL.Path, topic, "", nil)
+		ps.dbLogger.LogResponse(requestID, status, nil, time.Since(startTime), err)
+	}
+
+	http.Error(w, err.Error(), status)
+}
+
+// handleHealth reports whether the broker can deliver requests and receive replies
+func (ps *ProxyServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	status := map[string]string{"status": "ok", "broker": ps.cfg().Broker}
+	statusCode := http.StatusOK
+	if err := ps.broker.Health(ctx); err != nil {
+		log.Warn().Err(err).Msg("Health check failed")
+		status["status"] = "unavailable"
+		status["error"] = err.Error()
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(status)
+}
+
+// awaitReply registers a waiter for the replies to a correlation ID
+func (ps *ProxyServer) awaitReply(ctx context.Context, correlationID string) *ReplyWaiter {
+	_, span := tracer.Start(ctx, "subscribe reply")
+	defer span.End()
+
+	waiter := ps.broker.AwaitReply(correlationID)
+	span.SetAttributes(semconv.MessagingDestinationName(waiter.Topic))
+	return waiter
+}
+
+// publish adds the trace context to the headers of a message and publishes it.
+// Backends continuing the trace become children of the publish span.
+func (ps *ProxyServer) publish(ctx context.Context, logger zerolog.Logger, topic string, message Message) error {
+	ctx, span := tracer.Start(ctx, "publish "+topic, trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(semconv.MessagingSystemKey.String(ps.cfg().Broker), semconv.MessagingDestinationName(topic),
+			semconv.MessagingOperationTypePublish))
+	injectTraceContext(ctx, message.Header)
+
+	messageJSON, err := json.Marshal(message)
+	if err != nil {
+		err = fmt.Errorf("error creating message: %w", err)
+	} else {
+		err = ps.publishWithRetry(ctx, logger, topic, messageJSON)
+	}
+	endSpan(span, err)
+	return err
+}
+
+// publishWithRetry publishes a message, retrying for the configured grace period
+// while no backend is subscribed to the topic. ErrNoSubscribers is only returned
+// when failing on missing subscribers is enabled.
+func (ps *ProxyServer) publishWithRetry(ctx context.Context, logger zerolog.Logger, topic string, message []byte) error {
+	deadline := time.Now().Add(time.Duration(ps.cfg().NoSubscriberGraceMs) * time.Millisecond)
+
+	for attempt := 0; ; attempt++ {
+		err := ps.broker.Publish(ctx, topic, message)
+		if !errors.Is(err, ErrNoSubscribers) {
+			if err != nil {
+				ps.metrics.PublishError(topic)
+			}
+			return err
+		}
+
+		// Count the message once, not every retry
+		if attempt == 0 {
+			ps.metrics.NoSubscribers(topic)
+		}
+
+		if !ps.cfg().FailOnNoSubscribers {
+			// Keep the previous behavior and let the request run into the response timeout
+			logger.Warn().Str("topic", topic).Msg("No subscribers for topic, message was not delivered")
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return err
+		}
+
+		// Nobody received the message, so publishing it again is safe
+		logger.Debug().Str("topic", topic).Msg("No subscribers for topic, retrying publish")
+		select {
+		case <-time.After(noSubscriberRetryInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// handlePublishError logs a failed publish and writes the error response
+func (ps *ProxyServer) handlePublishError(w http.ResponseWriter, logger zerolog.Logger, requestID, topic string, startTime time.Time, err error) {
+	statusCode := http.StatusInternalServerError
+	message := "Error publishing to Redis"
+
+	if errors.Is(err, ErrNoSubscribers) {
+		logger.Error().Str("topic", topic).Msg("No backend subscribed to topic")
+		statusCode = http.StatusServiceUnavailable
+		message = fmt.Sprintf("No backend subscribed to topic %s", topic)
+		err = fmt.Errorf("%w for topic %s", ErrNoSubscribers, topic)
+	} else {
+		logger.Error().Err(err).Str("topic", topic).Msg("Error publishing to Redis")
+	}
+
+	// Log error response
+	if ps.dbLogger != nil && ps.dbLogger.enabled {
+		ps.dbLogger.LogResponse(requestID, statusCode, nil, time.Since(startTime), err)
+	}
+
+	http.Error(w, message, statusCode)
+}
+
+// requestHeaders builds the message headers of a request from its HTTP headers,
+// query parameters, path and method
+func requestHeaders(r *http.Request) map[string]interface{} {
+	headers := make(map[string]interface{})
+	for key, values := range r.Header {
+		if len(values) == 1 {
+			headers[key] = values[0]
+		} else {
+			headers[key] = values
+		}
+	}
+
+	// Add query parameters to headers
+	queryParams := r.URL.Query()
+	for key, values := range queryParams {
+		if len(values) == 1 {
+			headers["query_"+key] = values[0]
+		} else {
+			headers["query_"+key] = values
+		}
+	}
+
+	// Add path and method to headers
+	headers["path"] = r.URL.Path
+	headers["method"] = r.Method
+
+	return headers
+}
+
+// encodeResponseBody determines the content type of a response and encodes its body.
+// String bodies are written as-is for non-JSON content types, everything else is
+// encoded as JSON.
+func encodeResponseBody(response *Response) (string, []byte, error) {
+	contentType := response.ContentType
+	if contentType == "" {
+		for name, value := range response.Headers {
+			if http.CanonicalHeaderKey(name) == "Content-Type" {
+				if values, err := headerValues(value); err == nil && len(values) > 0 {
+					contentType = values[0]
+				}
+			}
+		}
+	}
+	if contentType == "" {
+		contentType = "application/json"
+	}
+
+	if response.Status == http.StatusNoContent || response.Status == http.StatusNotModified {
+		return contentType, nil, nil
+	}
+
+	if text, ok := response.Body.(string); ok && !isJSONContentType(contentType) {
+		return contentType, []byte(text), nil
+	}
+
+	data, err := json.Marshal(response.Body)
+	if err != nil {
+		return "", nil, err
+	}
+	return contentType, data, nil
+}
+
+// writeResponse writes the headers, status code and encoded body of a backend response
+func writeResponse(w http.ResponseWriter, response *Response, contentType string, data []byte) {
+	for name, value := range response.Headers {
+		values, err := headerValues(value)
+		if err != nil {
+			continue
+		}
+		for _, v := range values {
+			w.Header().Add(name, v)
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+
+	w.WriteHeader(response.Status)
+	if len(data) > 0 {
+		w.Write(data)
+	}
+}
+
+// isJSONContentType reports whether a content type denotes a JSON document
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -2,7 +2,12 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"net/url"
+	"os"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -10,53 +15,318 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// Transports for delivering requests to backends
+const (
+	TransportPubSub  = "pubsub"  // PUBLISH to a channel per topic, fire-and-forget
+	TransportStreams = "streams" // XADD to a stream per topic, consumed by consumer groups
+	TransportQueue   = "queue"   // LPUSH to a list per topic, popped by exactly one worker
+)
+
+// Redis deployment modes
+const (
+	RedisModeStandalone = "standalone" // A single Redis server
+	RedisModeSentinel   = "sentinel"   // A master monitored by Sentinel, with automatic failover
+	RedisModeCluster    = "cluster"    // A Redis Cluster
+)
+
+// streamMessageField is the stream entry field holding the JSON message
+const streamMessageField = "message"
+
+// ErrNoSubscribers is returned by Publish when a pub/sub message reached no subscriber
+var ErrNoSubscribers = errors.New("no subscribers")
+
 // RedisManager handles Redis connections and subscriptions
 type RedisManager struct {
-	client *redis.Client
-	logger zerolog.Logger
+	client       redis.UniversalClient
+	dispatcher   *responseDispatcher
+	transport    string
+	streamMaxLen int64
+	logger       zerolog.Logger
+
+	// Pub/sub publishing
+	shardedPubSub  bool // Publish with SPUBLISH to shard channels
+	countsReceived bool // PUBLISH reports all receivers, not only those on one cluster node
+}
+
+// redisOptions builds the client options from the Redis settings. Addresses,
+// credentials, database and TLS may also be given as redis:// or rediss:// URLs;
+// settings given separately take precedence over the URL.
+func redisOptions(config Config) (*redis.UniversalOptions, error) {
+	opts := &redis.UniversalOptions{
+		Addrs:            splitList(config.RedisAddr),
+		Username:         config.RedisUsername,
+		Password:         config.RedisPassword,
+		DB:               config.RedisDB,
+		MasterName:       config.RedisSentinelMaster,
+		SentinelUsername: config.RedisSentinelUsername,
+		SentinelPassword: config.RedisSentinelPassword,
+		PoolSize:         config.RedisPoolSize,
+		MinIdleConns:     config.RedisPoolSize / 4,
+		MaxRetries:       3,
+		DialTimeout:      5 * time.Second,
+		ReadTimeout:      5 * time.Second,
+		WriteTimeout:     5 * time.Second,
+		PoolTimeout:      6 * time.Second,
+	}
+
+	useTLS := config.RedisTLS
+	if config.RedisURL != "" {
+		opts.Addrs = nil
+		for i, redisURL := range splitList(config.RedisURL) {
+			parsed, err := redis.ParseURL(redisURL)
+			if err != nil {
+				// URL errors contain the URL, which may contain the password
+				var urlErr *url.Error
+				if errors.As(err, &urlErr) {
+					err = urlErr.Err
+				}
+				return nil, fmt.Errorf("REDIS_URL: invalid URL %d: %w", i+1, err)
+			}
+			opts.Addrs = append(opts.Addrs, parsed.Addr)
+
+			if i == 0 {
+				if opts.Username == "" {
+					opts.Username = parsed.Username
+				}
+				if opts.Password == "" {
+					opts.Password = parsed.Password
+				}
+				if opts.DB == 0 {
+					opts.DB = parsed.DB
+				}
+				useTLS = useTLS || parsed.TLSConfig != nil
+			}
+		}
+	}
+
+	if len(opts.Addrs) == 0 {
+		return nil, errors.New("REDIS_ADDR: no Redis address")
+	}
+	switch config.RedisMode {
+	case RedisModeStandalone:
+		if len(opts.Addrs) > 1 {
+			return nil, errors.New("REDIS_MODE: standalone mode takes a single address, set the mode for Sentinel or Cluster")
+		}
+	case RedisModeSentinel:
+		if opts.MasterName == "" {
+			return nil, errors.New("REDIS_SENTINEL_MASTER: required in sentinel mode")
+		}
+	case RedisModeCluster:
+		if opts.DB != 0 {
+			return nil, errors.New("REDIS_DB: Redis Cluster only supports database 0")
+		}
+	default:
+		return nil, fmt.Errorf("REDIS_MODE: unknown mode %q, expected %s, %s or %s",
+			config.RedisMode, RedisModeStandalone, RedisModeSentinel, RedisModeCluster)
+	}
+
+	if useTLS {
+		tlsConfig, err := redisTLSConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		opts.TLSConfig = tlsConfig
+	} else if config.RedisTLSCAFile != "" || config.RedisTLSCertFile != "" {
+		return nil, errors.New("REDIS_TLS: TLS files are set but TLS is not enabled")
+	}
+	return opts, nil
+}
+
+// redisTLSConfig builds the TLS configuration for Redis connections, with an
+// optional custom CA and client certificate
+func redisTLSConfig(config Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         config.RedisTLSServerName,
+		InsecureSkipVerify: config.RedisTLSInsecure,
+	}
+
+	if config.RedisTLSCAFile != "" {
+		pem, err := os.ReadFile(config.RedisTLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("REDIS_TLS_CA_FILE: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("REDIS_TLS_CA_FILE: no certificates found in %s", config.RedisTLSCAFile)
+		}
+	}
+
+	if (config.RedisTLSCertFile == "") != (config.RedisTLSKeyFile == "") {
+		return nil, errors.New("REDIS_TLS_CERT_FILE and REDIS_TLS_KEY_FILE must be set together")
+	}
+	if config.RedisTLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.RedisTLSCertFile, config.RedisTLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("REDIS_TLS_CERT_FILE: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// newRedisClient creates the client for the configured deployment mode
+func newRedisClient(mode string, opts *redis.UniversalOptions) redis.UniversalClient {
+	switch mode {
+	case RedisModeSentinel:
+		return redis.NewFailoverClient(opts.Failover())
+	case RedisModeCluster:
+		// A cluster client is used even for a single seed address
+		return redis.NewClusterClient(opts.Cluster())
+	default:
+		return redis.NewClient(opts.Simple())
+	}
+}
+
+// shardedPubSubAvailable reports whether the server knows SPUBLISH (Redis 7 or later)
+func shardedPubSubAvailable(ctx context.Context, client redis.UniversalClient) bool {
+	info, err := client.Do(ctx, "COMMAND", "INFO", "SPUBLISH").Slice()
+	return err == nil && len(info) > 0 && info[0] != nil
 }
 
 // NewRedisManager creates a new Redis manager
 func NewRedisManager(config Config) (*RedisManager, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:         config.RedisAddr,
-		Password:     config.RedisPassword,
-		DB:           config.RedisDB,
-		PoolSize:     config.RedisPoolSize,
-		MinIdleConns: config.RedisPoolSize / 4,
-		MaxRetries:   3,
-		DialTimeout:  5 * time.Second,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 5 * time.Second,
-		PoolTimeout:  6 * time.Second,
-	})
+	switch config.Transport {
+	case TransportPubSub, TransportStreams, TransportQueue:
+	default:
+		return nil, fmt.Errorf("unknown transport %q", config.Transport)
+	}
+
+	opts, err := redisOptions(config)
+	if err != nil {
+		return nil, err
+	}
+	client := newRedisClient(config.RedisMode, opts)
 
 	// Verify Redis connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	_, err := client.Ping(ctx).Result()
+	_, err = client.Ping(ctx).Result()
 	if err != nil {
+		client.Close()
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
-	return &RedisManager{
-		client: client,
-		logger: log.With().Str("component", "redisManager").Logger(),
-	}, nil
+	logger := log.With().Str("component", "redisManager").Logger()
+	logger.Info().Str("mode", config.RedisMode).Strs("addrs", opts.Addrs).Bool("tls", opts.TLSConfig != nil).
+		Msg("Connected to Redis")
+
+	// In a cluster, PUBLISH is broadcast to all nodes but only reports the
+	// receivers on the node it was sent to
+	rm := &RedisManager{
+		client:         client,
+		transport:      config.Transport,
+		streamMaxLen:   config.StreamMaxLen,
+		logger:         logger,
+		countsReceived: config.RedisMode != RedisModeCluster,
+	}
+	if config.RedisMode == RedisModeCluster && config.RedisShardedPubSub {
+		if shardedPubSubAvailable(ctx, client) {
+			rm.shardedPubSub = true
+			rm.countsReceived = true
+		} else {
+			logger.Warn().Msg("Sharded pub/sub requires Redis 7, publishing with PUBLISH instead")
+		}
+	}
+	if config.Transport == TransportPubSub && !rm.countsReceived && config.FailOnNoSubscribers {
+		logger.Warn().Msg("Missing subscribers can't be detected with PUBLISH in a cluster, enable REDIS_SHARDED_PUBSUB")
+	}
+
+	// Start the shared reply subscription
+	dispatcher, err := newResponseDispatcher(client, config, logger)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	rm.dispatcher = dispatcher
+	return rm, nil
 }
 
-// Publish publishes a message to Redis
+// Publish delivers a message to the backends of a topic using the configured transport.
+// With pub/sub, ErrNoSubscribers is returned if no backend received the message.
 func (rm *RedisManager) Publish(ctx context.Context, topic string, message []byte) error {
-	return rm.client.Publish(ctx, topic, message).Err()
+	switch rm.transport {
+	case TransportStreams:
+		// Streams keep the message until a consumer group member acknowledges it
+		return rm.client.XAdd(ctx, &redis.XAddArgs{
+			Stream: topic,
+			MaxLen: rm.streamMaxLen,
+			Approx: true,
+			Values: map[string]interface{}{streamMessageField: message},
+		}).Err()
+	case TransportQueue:
+		// Workers move messages into a processing list with BLMOVE and remove them once handled
+		return rm.client.LPush(ctx, topic, message).Err()
+	default:
+		receivers, err := rm.publishPubSub(ctx, topic, message)
+		if err != nil {
+			return err
+		}
+		if receivers == 0 && rm.countsReceived {
+			return ErrNoSubscribers
+		}
+		return nil
+	}
+}
+
+// PublishCancel publishes a cancellation. Cancellations always use pub/sub, regardless
+// of the transport, so that every backend of a topic sees them.
+func (rm *RedisManager) PublishCancel(ctx context.Context, cancelTopic string, cancellation []byte) error {
+	_, err := rm.publishPubSub(ctx, cancelTopic, cancellation)
+	return err
+}
+
+// publishPubSub publishes to a pub/sub channel, or to a shard channel with sharded
+// pub/sub, and returns the number of receivers
+func (rm *RedisManager) publishPubSub(ctx context.Context, channel string, message []byte) (int64, error) {
+	if rm.shardedPubSub {
+		return rm.client.SPublish(ctx, channel, message).Result()
+	}
+	return rm.client.Publish(ctx, channel, message).Result()
+}
+
+// ReplyTopic returns the reply topic for a correlation ID without waiting for replies
+func (rm *RedisManager) ReplyTopic(correlationID string) string {
+	return rm.dispatcher.ReplyTopic(correlationID)
+}
+
+// AwaitReply registers a waiter for replies to a correlation ID on the shared
+// reply subscription. Close the waiter once the request is done.
+func (rm *RedisManager) AwaitReply(correlationID string) *ReplyWaiter {
+	return rm.dispatcher.Register(correlationID)
+}
+
+// SetValue stores a value in a Redis key that expires after the TTL
+func (rm *RedisManager) SetValue(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return rm.client.Set(ctx, key, value, ttl).Err()
+}
+
+// GetValue returns the value of a Redis key, or ErrValueNotFound
+func (rm *RedisManager) GetValue(ctx context.Context, key string) ([]byte, error) {
+	value, err := rm.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrValueNotFound
+	}
+	return value, err
+}
+
+// Health pings Redis and checks that the reply subscriptions are up
+func (rm *RedisManager) Health(ctx context.Context) error {
+	if err := rm.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("redis ping failed: %w", err)
+	}
+	return rm.dispatcher.Healthy()
 }
 
-// Subscribe subscribes to a Redis topic
-func (rm *RedisManager) Subscribe(ctx context.Context, topic string) *redis.PubSub {
-	return rm.client.Subscribe(ctx, topic)
+// PoolStats returns the connection pool statistics of the Redis client
+func (rm *RedisManager) PoolStats() *redis.PoolStats {
+	return rm.client.PoolStats()
 }
 
-// Close closes the Redis client
+// Close stops the reply subscription and closes the Redis client
 func (rm *RedisManager) Close() error {
+	rm.dispatcher.Close()
 	return rm.client.Close()
 }
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Route modes
+const (
+	RouteModeSync      = "sync"      // Wait for the reply
+	RouteModeAsync     = "async"     // Respond with 202 and store the reply as a job
+	RouteModeImmediate = "immediate" // Respond immediately with the route's status code
+)
+
+// Kinds of route path segments
+const (
+	segmentLiteral  = iota // Matches the segment exactly
+	segmentParam           // {name} matches and captures one segment
+	segmentWildcard        // * matches one segment
+	segmentRest            // {name...} or ** matches the rest of the path
+)
+
+// topicPlaceholder matches the {name} placeholders of a topic template
+var topicPlaceholder = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// RouteTable maps HTTP routes to topics. Routes are matched in order.
+type RouteTable struct {
+	Routes []*Route `json:"routes"`
+}
+
+// Route maps requests matching a method and path pattern to a topic
+type Route struct {
+	Name            string   `json:"name,omitempty"`
+	Methods         []string `json:"methods,omitempty"`          // Empty matches any method
+	Path            string   `json:"path"`                       // e.g. /orders/{id}, /static/*, /files/{path...}
+	Topic           string   `json:"topic,omitempty"`            // Template like orders:{id}, empty uses the path-based topic
+	Timeout         int      `json:"timeout,omitempty"`          // Seconds to wait for the reply, overrides the global timeout
+	Mode            string   `json:"mode,omitempty"`             // sync, async or immediate, empty uses the global behavior
+	ImmediateStatus int      `json:"immediate_status,omitempty"` // Status code of immediate responses
+
+	segments []routeSegment
+}
+
+// routeSegment is a parsed segment of a route path pattern
+type routeSegment struct {
+	kind  int
+	value string // Literal text or parameter name
+}
+
+// RouteMatch is a route matching a request, with the extracted path parameters
+type RouteMatch struct {
+	Route  *Route
+	Params map[string]string
+}
+
+// LoadRouteTable reads and validates a routing table from a JSON file
+func LoadRouteTable(path string) (*RouteTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routes file: %w", err)
+	}
+
+	table, err := parseRouteTable(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid routes file %s: %w", path, err)
+	}
+	return table, nil
+}
+
+// parseRouteTable parses and validates a JSON routing table
+func parseRouteTable(data []byte) (*RouteTable, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var table RouteTable
+	if err := decoder.Decode(&table); err != nil {
+		return nil, err
+	}
+	if err := table.compile(); err != nil {
+		return nil, err
+	}
+	return &table, nil
+}
+
+// compile validates all routes and parses their path patterns
+func (rt *RouteTable) compile() error {
+	for i, route := range rt.Routes {
+		if route == nil {
+			return fmt.Errorf("route %d is empty", i+1)
+		}
+		if err := route.compile(); err != nil {
+			name := route.Name
+			if name == "" {
+				name = route.Path
+			}
+			return fmt.Errorf("route %d (%s): %w", i+1, name, err)
+		}
+	}
+	return nil
+}
+
+// compile validates a route and parses its path pattern
+func (r *Route) compile() error {
+	if !strings.HasPrefix(r.Path, "/") {
+		return fmt.Errorf("path must start with a slash")
+	}
+
+	params := make(map[string]bool)
+	r.segments = nil
+	parts := strings.Split(strings.Trim(r.Path, "/"), "/")
+	for i, part := range parts {
+		var segment routeSegment
+		switch {
+		case part == "" && len(parts) == 1:
+			// The root path has no segments
+			continue
+		case part == "":
+			return fmt.Errorf("path contains an empty segment")
+		case part == "*":
+			segment = routeSegment{kind: segmentWildcard}
+		case part == "**":
+			segment = routeSegment{kind: segmentRest}
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "...}"):
+			segment = routeSegment{kind: segmentRest, value: strings.TrimSuffix(part[1:], "...}")}
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			segment = routeSegment{kind: segmentParam, value: part[1 : len(part)-1]}
+		case strings.ContainsAny(part, "{}*"):
+			return fmt.Errorf("invalid path segment %q", part)
+		default:
+			segment = routeSegment{kind: segmentLiteral, value: part}
+		}
+
+		if segment.kind == segmentRest && i != len(parts)-1 {
+			return fmt.Errorf("%q must be the last path segment", part)
+		}
+		if segment.kind == segmentParam || (segment.kind == segmentRest && segment.value != "") {
+			if !topicPlaceholder.MatchString("{" + segment.value + "}") {
+				return fmt.Errorf("invalid parameter name %q", segment.value)
+			}
+			if params[segment.value] {
+				return fmt.Errorf("duplicate parameter %q", segment.value)
+			}
+			params[segment.value] = true
+		}
+		r.segments = append(r.segments, segment)
+	}
+
+	for _, placeholder := range topicPlaceholder.FindAllStringSubmatch(r.Topic, -1) {
+		if !params[placeholder[1]] {
+			return fmt.Errorf("topic references unknown parameter %q", placeholder[1])
+		}
+	}
+
+	for i, method := range r.Methods {
+		r.Methods[i] = strings.ToUpper(strings.TrimSpace(method))
+	}
+
+	switch r.Mode {
+	case "", RouteModeSync, RouteModeAsync, RouteModeImmediate:
+	default:
+		return fmt.Errorf("unknown mode %q, expected %s, %s or %s", r.Mode, RouteModeSync, RouteModeAsync, RouteModeImmediate)
+	}
+	if r.ImmediateStatus != 0 && (r.ImmediateStatus < 100 || r.ImmediateStatus > 599) {
+		return fmt.Errorf("invalid immediate status %d", r.ImmediateStatus)
+	}
+	if r.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	return nil
+}
+
+// Match returns the first route matching a request, or nil if none matches
+func (rt *RouteTable) Match(method, path string) *RouteMatch {
+	var parts []string
+	if trimmed := strings.Trim(path, "/"); trimmed != "" {
+		parts = strings.Split(trimmed, "/")
+	}
+
+	for _, route := range rt.Routes {
+		if !route.matchesMethod(method) {
+			continue
+		}
+		if params, ok := route.matchPath(parts); ok {
+			return &RouteMatch{Route: route, Params: params}
+		}
+	}
+	return nil
+}
+
+// matchesMethod reports whether a route accepts a request method
+func (r *Route) matchesMethod(method string) bool {
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, m := range r.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath matches the segments of a request path and extracts the path parameters
+func (r *Route) matchPath(parts []string) (map[string]string, bool) {
+	params := make(map[string]string)
+	for i, segment := range r.segments {
+		if segment.kind == segmentRest {
+			if segment.value != "" {
+				params[segment.value] = strings.Join(parts[i:], "/")
+			}
+			return params, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+
+		switch segment.kind {
+		case segmentLiteral:
+			if parts[i] != segment.value {
+				return nil, false
+			}
+		case segmentParam:
+			params[segment.value] = parts[i]
+		}
+	}
+	return params, len(parts) == len(r.segments)
+}
+
+// Topic returns the topic of a matched request, filling the topic template with the
+// path parameters. Without a template the path-based topic is used.
+func (m *RouteMatch) Topic(path string) string {
+	if m.Route.Topic == "" {
+		return createTopicFromPath(path)
+	}
+	return topicPlaceholder.ReplaceAllStringFunc(m.Route.Topic, func(placeholder string) string {
+		// Parameters spanning several segments use the Redis separator as well
+		return strings.ReplaceAll(m.Params[placeholder[1:len(placeholder)-1]], "/", ":")
+	})
+}
+
+// requestPlan describes how a request is handled, from the global configuration
+// and the route it matched
+type requestPlan struct {
+	route           *RouteMatch // nil without a routing table
+	topic           string
+	asyncJob        bool
+	immediateStatus int // Respond immediately with this status if > 0
+	callback        bool
+	timeout         int // Seconds to wait for the reply, 0 if no reply is awaited
+}
+
+// routeName returns the name of the matched route, empty without a routing table
+func (p *requestPlan) routeName() string {
+	if p.route == nil {
+		return ""
+	}
+	return p.route.Route.Name
+}
+
+// planRequest determines the topic, mode and timeout of a request. It returns nil
+// if a routing table is configured and no route matches.
+func (ps *ProxyServer) planRequest(r *http.Request) *requestPlan {
+	config := ps.cfg()
+	plan := &requestPlan{
+		topic:           config.FixedTopic,
+		asyncJob:        ps.wantsAsyncJob(r),
+		immediateStatus: config.RespondImmediatelyStatus,
+		callback:        strings.TrimSpace(r.Header.Get(config.CallbackHeader)) != "",
+	}
+	if plan.topic == "" {
+		plan.topic = createTopicFromPath(r.URL.Path)
+	}
+
+	var route *Route
+	if config.Routes != nil {
+		plan.route = config.Routes.Match(r.Method, r.URL.Path)
+		if plan.route == nil {
+			return nil
+		}
+		route = plan.route.Route
+		plan.topic = plan.route.Topic(r.URL.Path)
+
+		switch {
+		case route.Mode == RouteModeSync:
+			plan.asyncJob = false
+			plan.immediateStatus = 0
+		case route.Mode == RouteModeAsync:
+			plan.asyncJob = true
+		case route.Mode == RouteModeImmediate || route.ImmediateStatus > 0:
+			plan.asyncJob = false
+			if route.ImmediateStatus > 0 {
+				plan.immediateStatus = route.ImmediateStatus
+			} else if plan.immediateStatus == 0 {
+				plan.immediateStatus = http.StatusAccepted
+			}
+		}
+	}
+
+	switch {
+	case plan.asyncJob:
+		plan.timeout = config.JobTimeout
+	case plan.immediateStatus > 0 && !plan.callback:
+		return plan
+	case plan.immediateStatus > 0:
+		plan.timeout = config.CallbackTimeout
+	default:
+		plan.timeout = config.ResponseTimeout
+	}
+	if route != nil && route.Timeout > 0 {
+		plan.timeout = route.Timeout
+	}
+	return plan
+}
@@ -74,6 +74,34 @@ func main() {
 	}
 	log.Printf("Connected to Redis at %s", redisAddr)
 
+	// Graceful shutdown handling
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	// Consume from streams or queues instead of pub/sub if configured
+	transport := strings.ToLower(getEnv("TRANSPORT", "pubsub"))
+	if transport == "streams" || transport == "queue" {
+		if usePattern {
+			log.Fatalf("USE_PATTERN is not supported with TRANSPORT=%s", transport)
+		}
+		go func() {
+			sig := <-sigCh
+			log.Printf("Received signal: %v, shutting down...", sig)
+			cancel()
+		}()
+
+		var err error
+		if transport == "streams" {
+			err = newStreamConsumer(client, redisTopics, responseDelay, debug).Run(ctx)
+		} else {
+			err = newQueueConsumer(client, redisTopics, responseDelay, debug).Run(ctx)
+		}
+		if err != nil {
+			log.Fatalf("Consumer failed: %v", err)
+		}
+		return
+	}
+
 	// Create a pubsub client
 	var pubsub *redis.PubSub
 	if usePattern {
@@ -93,10 +121,6 @@ func main() {
 	// Channel to receive messages
 	ch := pubsub.Channel()
 
-	// Graceful shutdown handling
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-
 	log.Printf("Echo server ready, response delay: %dms, debug: %v", responseDelay, debug)
 
 	// Process messages
@@ -114,7 +138,10 @@ func main() {
 	}
 }
 
-func handleMessage(ctx context.Context, client *redis.Client, channel, payload string, delayMs int, debug bool) {
+// handleMessage echoes a request message to its response topic. Messages that
+// cannot be processed are logged and skipped; an error is only returned when the
+// reply could not be published, so queue and stream consumers can retry them.
+func handleMessage(ctx context.Context, client *redis.Client, channel, payload string, delayMs int, debug bool) error {
 	if debug {
 		log.Printf("[DEBUG] Processing message: %s", truncate(payload, 100))
 	}
@@ -124,7 +151,7 @@ func handleMessage(ctx context.Context, client *redis.Client, channel, payload s
 	if err := json.Unmarshal([]byte(payload), &incomingMsg); err != nil {
 		log.Printf("Error parsing message: %v", err)
 		log.Printf("Raw message: %s", payload)
-		return
+		return nil
 	}
 
 	// Get response topic
@@ -133,7 +160,7 @@ func handleMessage(ctx context.Context, client *redis.Client, channel, payload s
 		responseTopic = rt
 	} else {
 		log.Printf("No response_topic in header or wrong format, skipping message")
-		return
+		return nil
 	}
 
 	if debug {
@@ -158,6 +185,14 @@ func handleMessage(ctx context.Context, client *redis.Client, channel, payload s
 		time.Sleep(time.Duration(delayMs) * time.Millisecond)
 	}
 
+	// Skip the reply if the proxy has stopped waiting for it
+	if deadline, ok := incomingMsg.Header["deadline"].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, deadline); err == nil && time.Now().After(t) {
+			log.Printf("Deadline %s passed, dropping reply to %s", deadline, responseTopic)
+			return nil
+		}
+	}
+
 	// Create echo response
 	response := Response{
 		Body: map[string]interface{}{
@@ -174,7 +209,7 @@ func handleMessage(ctx context.Context, client *redis.Client, channel, payload s
 	responseJSON, err := json.Marshal(response)
 	if err != nil {
 		log.Printf("Error creating response: %v", err)
-		return
+		return nil
 	}
 
 	if debug {
@@ -184,10 +219,11 @@ func handleMessage(ctx context.Context, client *redis.Client, channel, payload s
 	// Publish to the response topic
 	if err := client.Publish(ctx, responseTopic, responseJSON).Err(); err != nil {
 		log.Printf("Error publishing to response topic %s: %v", responseTopic, err)
-		return
+		return err
 	}
 
 	log.Printf("Echoed message to %s", responseTopic)
+	return nil
 }
 
 // Helper function to truncate string for logging
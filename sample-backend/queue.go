@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// queueConsumer pops request messages from Redis lists. Each message is moved
+// atomically into a processing list owned by this worker and removed from it
+// once handled, so messages of a crashed worker can be put back on the queue.
+type queueConsumer struct {
+	client          *redis.Client
+	queues          []string
+	consumer        string
+	heartbeatTTL    time.Duration
+	recoverInterval time.Duration
+	delayMs         int
+	debug           bool
+	wg              sync.WaitGroup
+}
+
+// newQueueConsumer creates a queue consumer from environment variables
+func newQueueConsumer(client *redis.Client, queues []string, delayMs int, debug bool) *queueConsumer {
+	hostname, _ := os.Hostname()
+	return &queueConsumer{
+		client:          client,
+		queues:          queues,
+		consumer:        getEnv("CONSUMER_NAME", fmt.Sprintf("%s-%d", hostname, os.Getpid())),
+		heartbeatTTL:    time.Duration(getEnvAsInt("HEARTBEAT_TTL_MS", 15000)) * time.Millisecond,
+		recoverInterval: time.Duration(getEnvAsInt("RECOVER_INTERVAL_MS", 30000)) * time.Millisecond,
+		delayMs:         delayMs,
+		debug:           debug,
+	}
+}
+
+// processingList returns the processing list of a consumer for a queue
+func processingList(queue, consumer string) string {
+	return queue + ":processing:" + consumer
+}
+
+// heartbeatKey returns the key a consumer refreshes while it is alive
+func heartbeatKey(processing string) string {
+	return processing + ":heartbeat"
+}
+
+// Run processes messages until ctx is cancelled
+func (qc *queueConsumer) Run(ctx context.Context) error {
+	if err := qc.heartbeat(ctx); err != nil {
+		return fmt.Errorf("failed to write heartbeat: %w", err)
+	}
+
+	// Messages left in our own processing lists were never finished by a previous run
+	for _, queue := range qc.queues {
+		qc.requeue(ctx, queue, processingList(queue, qc.consumer))
+	}
+
+	log.Printf("Consuming queues %s as %s, response delay: %dms, debug: %v",
+		strings.Join(qc.queues, ", "), qc.consumer, qc.delayMs, qc.debug)
+
+	go qc.maintenanceLoop(ctx)
+
+	for _, queue := range qc.queues {
+		qc.wg.Add(1)
+		go func(queue string) {
+			defer qc.wg.Done()
+			qc.consume(ctx, queue)
+		}(queue)
+	}
+
+	<-ctx.Done()
+	qc.wg.Wait()
+	return nil
+}
+
+// consume pops messages from a single queue until ctx is cancelled
+func (qc *queueConsumer) consume(ctx context.Context, queue string) {
+	processing := processingList(queue, qc.consumer)
+
+	for {
+		payload, err := qc.client.BLMove(ctx, queue, processing, "RIGHT", "LEFT", 5*time.Second).Result()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			log.Printf("Error popping from queue %s: %v", queue, err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		if qc.debug {
+			log.Printf("[DEBUG] Popped message from queue: %s", queue)
+		}
+
+		qc.wg.Add(1)
+		go func() {
+			defer qc.wg.Done()
+
+			// Failed messages stay in the processing list and are requeued later
+			if err := handleMessage(ctx, qc.client, queue, payload, qc.delayMs, qc.debug); err != nil {
+				return
+			}
+
+			// Remove the message from the processing list now that it is handled
+			if err := qc.client.LRem(context.Background(), processing, 1, payload).Err(); err != nil {
+				log.Printf("Error removing message from %s: %v", processing, err)
+			}
+		}()
+	}
+}
+
+// maintenanceLoop refreshes the heartbeat and recovers messages of dead consumers
+func (qc *queueConsumer) maintenanceLoop(ctx context.Context) {
+	heartbeatTicker := time.NewTicker(qc.heartbeatTTL / 3)
+	defer heartbeatTicker.Stop()
+	recoverTicker := time.NewTicker(qc.recoverInterval)
+	defer recoverTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeatTicker.C:
+			if err := qc.heartbeat(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Error writing heartbeat: %v", err)
+			}
+		case <-recoverTicker.C:
+			for _, queue := range qc.queues {
+				qc.recoverDeadConsumers(ctx, queue)
+			}
+		}
+	}
+}
+
+// heartbeat marks the processing lists of this consumer as owned by a live worker
+func (qc *queueConsumer) heartbeat(ctx context.Context) error {
+	pipe := qc.client.Pipeline()
+	for _, queue := range qc.queues {
+		pipe.Set(ctx, heartbeatKey(processingList(queue, qc.consumer)), time.Now().Unix(), qc.heartbeatTTL)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// recoverDeadConsumers moves messages from processing lists whose owner stopped
+// sending heartbeats back onto the queue
+func (qc *queueConsumer) recoverDeadConsumers(ctx context.Context, queue string) {
+	iter := qc.client.Scan(ctx, 0, processingList(queue, "*"), 100).Iterator()
+	for iter.Next(ctx) {
+		processing := iter.Val()
+		if strings.HasSuffix(processing, ":heartbeat") {
+			continue
+		}
+
+		alive, err := qc.client.Exists(ctx, heartbeatKey(processing)).Result()
+		if err != nil || alive > 0 {
+			continue
+		}
+
+		qc.requeue(ctx, queue, processing)
+	}
+	if err := iter.Err(); err != nil && ctx.Err() == nil {
+		log.Printf("Error scanning processing lists of %s: %v", queue, err)
+	}
+}
+
+// requeue moves all messages from a processing list back onto the queue
+func (qc *queueConsumer) requeue(ctx context.Context, queue, processing string) {
+	count := 0
+	for {
+		err := qc.client.LMove(ctx, processing, queue, "RIGHT", "RIGHT").Err()
+		if err != nil {
+			if !errors.Is(err, redis.Nil) && ctx.Err() == nil {
+				log.Printf("Error requeueing messages from %s: %v", processing, err)
+			}
+			break
+		}
+		count++
+	}
+
+	if count > 0 {
+		log.Printf("Requeued %d unfinished messages from %s", count, processing)
+	}
+}
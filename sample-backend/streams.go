@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// streamMessageField is the stream entry field holding the JSON message
+const streamMessageField = "message"
+
+// streamConsumer reads request messages from Redis streams as a member of a
+// consumer group, acknowledges them once handled and reclaims entries left
+// pending by crashed consumers
+type streamConsumer struct {
+	client        *redis.Client
+	streams       []string
+	group         string
+	consumer      string
+	claimMinIdle  time.Duration
+	claimInterval time.Duration
+	delayMs       int
+	debug         bool
+	wg            sync.WaitGroup
+}
+
+// newStreamConsumer creates a stream consumer from environment variables
+func newStreamConsumer(client *redis.Client, streams []string, delayMs int, debug bool) *streamConsumer {
+	hostname, _ := os.Hostname()
+	return &streamConsumer{
+		client:        client,
+		streams:       streams,
+		group:         getEnv("CONSUMER_GROUP", "echo-servers"),
+		consumer:      getEnv("CONSUMER_NAME", fmt.Sprintf("%s-%d", hostname, os.Getpid())),
+		claimMinIdle:  time.Duration(getEnvAsInt("CLAIM_MIN_IDLE_MS", 60000)) * time.Millisecond,
+		claimInterval: time.Duration(getEnvAsInt("CLAIM_INTERVAL_MS", 30000)) * time.Millisecond,
+		delayMs:       delayMs,
+		debug:         debug,
+	}
+}
+
+// Run creates the consumer groups and processes messages until ctx is cancelled
+func (sc *streamConsumer) Run(ctx context.Context) error {
+	for _, stream := range sc.streams {
+		err := sc.client.XGroupCreateMkStream(ctx, stream, sc.group, "0").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return fmt.Errorf("failed to create consumer group on %s: %w", stream, err)
+		}
+	}
+
+	log.Printf("Consuming streams %s as %s/%s, response delay: %dms, debug: %v",
+		strings.Join(sc.streams, ", "), sc.group, sc.consumer, sc.delayMs, sc.debug)
+
+	go sc.reclaimLoop(ctx)
+
+	// XREADGROUP expects all stream names followed by one ID per stream
+	args := make([]string, 0, len(sc.streams)*2)
+	args = append(args, sc.streams...)
+	for range sc.streams {
+		args = append(args, ">")
+	}
+
+	for {
+		results, err := sc.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    sc.group,
+			Consumer: sc.consumer,
+			Streams:  args,
+			Count:    10,
+			Block:    5 * time.Second,
+		}).Result()
+		if err != nil {
+			if ctx.Err() != nil {
+				sc.wg.Wait()
+				return nil
+			}
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			log.Printf("Error reading from streams: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		for _, result := range results {
+			for _, entry := range result.Messages {
+				sc.process(ctx, result.Stream, entry)
+			}
+		}
+	}
+}
+
+// reclaimLoop periodically claims entries that stayed pending longer than
+// claimMinIdle, which happens when a consumer crashed before acknowledging
+func (sc *streamConsumer) reclaimLoop(ctx context.Context) {
+	ticker := time.NewTicker(sc.claimInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, stream := range sc.streams {
+			start := "0-0"
+			for {
+				entries, next, err := sc.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+					Stream:   stream,
+					Group:    sc.group,
+					Consumer: sc.consumer,
+					MinIdle:  sc.claimMinIdle,
+					Start:    start,
+					Count:    10,
+				}).Result()
+				if err != nil {
+					if ctx.Err() == nil {
+						log.Printf("Error reclaiming pending entries on %s: %v", stream, err)
+					}
+					break
+				}
+
+				for _, entry := range entries {
+					log.Printf("Reclaimed pending entry %s on %s", entry.ID, stream)
+					sc.process(ctx, stream, entry)
+				}
+
+				if next == "0-0" || len(entries) == 0 {
+					break
+				}
+				start = next
+			}
+		}
+	}
+}
+
+// process handles a stream entry in the background and acknowledges it afterwards
+func (sc *streamConsumer) process(ctx context.Context, stream string, entry redis.XMessage) {
+	payload, ok := entry.Values[streamMessageField].(string)
+	if !ok {
+		log.Printf("Stream entry %s on %s has no %q field, acknowledging", entry.ID, stream, streamMessageField)
+		sc.ack(ctx, stream, entry.ID)
+		return
+	}
+
+	if sc.debug {
+		log.Printf("[DEBUG] Received entry %s on stream: %s", entry.ID, stream)
+	}
+
+	sc.wg.Add(1)
+	go func() {
+		defer sc.wg.Done()
+		// Unacknowledged entries stay pending and are reclaimed later
+		if err := handleMessage(ctx, sc.client, stream, payload, sc.delayMs, sc.debug); err != nil {
+			return
+		}
+		sc.ack(ctx, stream, entry.ID)
+	}()
+}
+
+// ack acknowledges a stream entry so it is removed from the pending list
+func (sc *streamConsumer) ack(ctx context.Context, stream, id string) {
+	if err := sc.client.XAck(ctx, stream, sc.group, id).Err(); err != nil {
+		log.Printf("Error acknowledging entry %s on %s: %v", id, stream, err)
+	}
+}
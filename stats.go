@@ -4,31 +4,175 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
+// maxStatsBuckets limits the length of the time series of a stats query
+const maxStatsBuckets = 2000
+
+// failedRequestCondition matches requests answered with an error status or an error message
+const failedRequestCondition = "(status_code >= 400 OR error <> '')"
+
+// statsBucketSizes are the bucket sizes chosen for a time range when none is given
+var statsBucketSizes = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour, 7 * 24 * time.Hour}
+
+// errInvalidStatsQuery is returned for stats queries with an invalid range or bucket size
+var errInvalidStatsQuery = errors.New("invalid stats query")
+
 // RequestStats represents statistics about requests
 type RequestStats struct {
-	Timestamp            time.Time      `json:"timestamp"`
-	TotalRequests        int            `json:"total_requests"`
-	SuccessfulRequests   int            `json:"successful_requests"`
-	FailedRequests       int            `json:"failed_requests"`
-	AverageResponseTime  float64        `json:"average_response_time_ms"`
-	MinResponseTime      int64          `json:"min_response_time_ms"`
-	MaxResponseTime      int64          `json:"max_response_time_ms"`
-	TimeoutRequests      int            `json:"timeout_requests"`
-	RequestsByStatusCode map[int]int    `json:"requests_by_status_code"`
-	RequestsByTopic      map[string]int `json:"requests_by_topic"`
-	Period               string         `json:"period"`
-	WindowSize           int            `json:"window_size"`
+	Timestamp               time.Time               `json:"timestamp"`
+	TotalRequests           int                     `json:"total_requests"`
+	SuccessfulRequests      int                     `json:"successful_requests"`
+	FailedRequests          int                     `json:"failed_requests"`
+	AverageResponseTime     float64                 `json:"average_response_time_ms"`
+	MinResponseTime         int64                   `json:"min_response_time_ms"`
+	MaxResponseTime         int64                   `json:"max_response_time_ms"`
+	ResponseTimePercentiles LatencyPercentiles      `json:"response_time_percentiles"`
+	TimeoutRequests         int                     `json:"timeout_requests"`
+	NoSubscriberRequests    int                     `json:"no_subscriber_requests"`
+	WebSocketConnections    int                     `json:"websocket_connections"`
+	WebSocketSessions       int                     `json:"websocket_sessions"`
+	WebSocketMessagesIn     int64                   `json:"websocket_messages_in"`
+	WebSocketMessagesOut    int64                   `json:"websocket_messages_out"`
+	RequestsByStatusCode    map[int]int             `json:"requests_by_status_code"`
+	RequestsByTopic         map[string]int          `json:"requests_by_topic"`
+	RequestsByMethod        map[string]int          `json:"requests_by_method"`
+	LatencyByTopic          map[string]TopicLatency `json:"latency_by_topic"`
+	TimeSeries              []StatsBucket           `json:"time_series"`
+	Period                  string                  `json:"period"`
+	From                    time.Time               `json:"from"`
+	To                      time.Time               `json:"to"`
+	BucketSeconds           int64                   `json:"bucket_seconds"`
+	WindowSize              int                     `json:"window_size"`
+}
+
+// LatencyPercentiles holds response time percentiles in milliseconds
+type LatencyPercentiles struct {
+	P50 int64 `json:"p50_ms"`
+	P90 int64 `json:"p90_ms"`
+	P95 int64 `json:"p95_ms"`
+	P99 int64 `json:"p99_ms"`
+}
+
+// TopicLatency summarizes the response times of a topic
+type TopicLatency struct {
+	Requests            int     `json:"requests"`
+	AverageResponseTime float64 `json:"average_response_time_ms"`
+	MaxResponseTime     int64   `json:"max_response_time_ms"`
+	LatencyPercentiles
+}
+
+// StatsBucket holds the requests of one interval of the time series
+type StatsBucket struct {
+	Start           time.Time `json:"start"`
+	Requests        int       `json:"requests"`
+	Errors          int       `json:"errors"`
+	P95ResponseTime int64     `json:"p95_response_time_ms"`
+}
+
+// StatsQuery selects the requests that statistics are computed for
+type StatsQuery struct {
+	Period     string        // "hour", "day", "week", "month" or "all" up to now, ignored if From is set
+	From       time.Time     // Start of a custom range
+	To         time.Time     // End of a custom range, zero for now
+	Bucket     time.Duration // Interval of the time series, zero chooses one from the range
+	WindowSize int           // Number of topics listed
+}
+
+// parseStatsQuery reads a stats query from the "period", "from", "to", "bucket"
+// and "window_size" parameters. Times are RFC 3339 timestamps or Unix seconds,
+// the bucket size is a duration such as "1m" or "1h".
+func parseStatsQuery(r *http.Request, defaultPeriod string) (StatsQuery, error) {
+	params := r.URL.Query()
+	query := StatsQuery{Period: params.Get("period"), WindowSize: 10} // Default to top 10
+	if query.Period == "" {
+		query.Period = defaultPeriod
+	}
+
+	if parsed, err := strconv.Atoi(params.Get("window_size")); err == nil && parsed > 0 {
+		query.WindowSize = parsed
+	}
+
+	var err error
+	if value := params.Get("from"); value != "" {
+		if query.From, err = parseTimeParam(value); err != nil {
+			return query, fmt.Errorf("%w: from: %v", errInvalidStatsQuery, err)
+		}
+	}
+	if value := params.Get("to"); value != "" {
+		if query.To, err = parseTimeParam(value); err != nil {
+			return query, fmt.Errorf("%w: to: %v", errInvalidStatsQuery, err)
+		}
+		if query.From.IsZero() {
+			return query, fmt.Errorf("%w: to requires from", errInvalidStatsQuery)
+		}
+		if !query.To.After(query.From) {
+			return query, fmt.Errorf("%w: to must be after from", errInvalidStatsQuery)
+		}
+	}
+	if value := params.Get("bucket"); value != "" {
+		if query.Bucket, err = time.ParseDuration(value); err != nil {
+			return query, fmt.Errorf("%w: bucket: %v", errInvalidStatsQuery, err)
+		}
+		if query.Bucket < time.Second {
+			return query, fmt.Errorf("%w: bucket must be at least 1s", errInvalidStatsQuery)
+		}
+	}
+	return query, nil
+}
+
+// parseTimeParam parses an RFC 3339 timestamp or Unix seconds
+func parseTimeParam(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// statsBucketFor chooses the smallest bucket size that splits a range into at most 120 buckets
+func statsBucketFor(span time.Duration) time.Duration {
+	for _, size := range statsBucketSizes {
+		if span/size <= 120 {
+			return size
+		}
+	}
+	return statsBucketSizes[len(statsBucketSizes)-1]
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// latencyPercentiles returns the percentiles of sorted response times
+func latencyPercentiles(sorted []int64) LatencyPercentiles {
+	return LatencyPercentiles{
+		P50: percentile(sorted, 50),
+		P90: percentile(sorted, 90),
+		P95: percentile(sorted, 95),
+		P99: percentile(sorted, 99),
+	}
 }
 
 // getRequestStats retrieves statistics about requests from the database
-func (l *DBLogger) getRequestStats(ctx context.Context, period string, windowSize int) (*RequestStats, error) {
+func (l *DBLogger) getRequestStats(ctx context.Context, statsQuery StatsQuery) (*RequestStats, error) {
 	if !l.enabled {
 		return nil, nil
 	}
@@ -36,59 +180,70 @@ func (l *DBLogger) getRequestStats(ctx context.Context, period string, windowSiz
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
-	// Determine time window based on period
-	var timeWindow time.Time
+	// Determine time window based on period, or use the custom range
 	now := time.Now()
+	period := statsQuery.Period
+	from, to := statsQuery.From, statsQuery.To
+	if !from.IsZero() {
+		period = "custom"
+	} else {
+		switch period {
+		case "hour":
+			from = now.Add(-1 * time.Hour)
+		case "day":
+			from = now.AddDate(0, 0, -1)
+		case "week":
+			from = now.AddDate(0, 0, -7)
+		case "month":
+			from = now.AddDate(0, -1, 0)
+		case "all":
+			// No time window filter
+		default:
+			// Default to last hour
+			period = "hour"
+			from = now.Add(-1 * time.Hour)
+		}
+	}
 
-	switch period {
-	case "hour":
-		timeWindow = now.Add(-1 * time.Hour)
-	case "day":
-		timeWindow = now.AddDate(0, 0, -1)
-	case "week":
-		timeWindow = now.AddDate(0, 0, -7)
-	case "month":
-		timeWindow = now.AddDate(0, -1, 0)
-	case "all":
-		// No time window filter
-	default:
-		// Default to last hour
-		period = "hour"
-		timeWindow = now.Add(-1 * time.Hour)
-	}
-
-	var whereClause string
+	// Requests are compared by their local time, as they are stored
+	var rangeConditions []string
 	var args []interface{}
-	if period != "all" {
-		whereClause = "WHERE timestamp >= datetime(?)"
-		args = append(args, timeWindow.Format("2006-01-02 15:04:05"))
+	if !from.IsZero() {
+		rangeConditions = append(rangeConditions, "timestamp >= datetime(?)")
+		args = append(args, from.Local().Format("2006-01-02 15:04:05"))
+	}
+	if !to.IsZero() {
+		rangeConditions = append(rangeConditions, "timestamp < datetime(?)")
+		args = append(args, to.Local().Format("2006-01-02 15:04:05"))
+	}
+
+	// where combines conditions with the time range
+	where := func(conditions ...string) string {
+		conditions = append(conditions, rangeConditions...)
+		if len(conditions) == 0 {
+			return ""
+		}
+		return " WHERE " + strings.Join(conditions, " AND ")
 	}
 
 	// Get total requests
 	var totalRequests int
-	query := "SELECT COUNT(*) FROM request_logs " + whereClause
-	err := l.db.QueryRowContext(ctx, query, args...).Scan(&totalRequests)
+	err := l.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM request_logs"+where(), args...).Scan(&totalRequests)
 	if err != nil {
 		return nil, err
 	}
 
 	// Get successful requests (status code 2xx)
 	var successfulRequests int
-	query = "SELECT COUNT(*) FROM request_logs WHERE status_code >= 200 AND status_code < 300 "
-	if whereClause != "" {
-		query += "AND timestamp >= datetime(?)"
-	}
+	query := "SELECT COUNT(*) FROM request_logs" + where("status_code >= 200", "status_code < 300")
 	err = l.db.QueryRowContext(ctx, query, args...).Scan(&successfulRequests)
 	if err != nil {
 		return nil, err
 	}
 
-	// Get failed requests (status code >= 400 or error not null)
+	// Get failed requests (status code >= 400 or an error message)
 	var failedRequests int
-	query = "SELECT COUNT(*) FROM request_logs WHERE (status_code >= 400 OR error IS NOT NULL) "
-	if whereClause != "" {
-		query += "AND timestamp >= datetime(?)"
-	}
+	query = "SELECT COUNT(*) FROM request_logs" + where(failedRequestCondition)
 	err = l.db.QueryRowContext(ctx, query, args...).Scan(&failedRequests)
 	if err != nil {
 		return nil, err
@@ -96,23 +251,31 @@ func (l *DBLogger) getRequestStats(ctx context.Context, period string, windowSiz
 
 	// Get timeout requests (status code 504 or error message containing "timeout")
 	var timeoutRequests int
-	query = "SELECT COUNT(*) FROM request_logs WHERE status_code = 504 OR error LIKE '%timeout%' "
-	if whereClause != "" {
-		query += "AND timestamp >= datetime(?)"
-	}
+	query = "SELECT COUNT(*) FROM request_logs" + where("(status_code = 504 OR error LIKE '%timeout%')")
 	err = l.db.QueryRowContext(ctx, query, args...).Scan(&timeoutRequests)
 	if err != nil {
 		return nil, err
 	}
 
+	// Get requests rejected because no backend was subscribed to the topic
+	var noSubscriberRequests int
+	query = "SELECT COUNT(*) FROM request_logs" + where("status_code = 503", "error LIKE 'no subscribers%'")
+	err = l.db.QueryRowContext(ctx, query, args...).Scan(&noSubscriberRequests)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get open WebSocket connections and sessions within the period
+	wsActive, wsSessions, wsIn, wsOut, err := l.countWebSocketSessions(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
 	// Get average, min, max response time
-	var avgResponseTime float64
+	var avgResponseTime sql.NullFloat64
 	var minResponseTime sql.NullInt64
 	var maxResponseTime sql.NullInt64
-	query = "SELECT AVG(response_time), MIN(response_time), MAX(response_time) FROM request_logs WHERE response_time > 0 "
-	if whereClause != "" {
-		query += "AND timestamp >= datetime(?)"
-	}
+	query = "SELECT AVG(response_time), MIN(response_time), MAX(response_time) FROM request_logs" + where("response_time > 0")
 	err = l.db.QueryRowContext(ctx, query, args...).Scan(&avgResponseTime, &minResponseTime, &maxResponseTime)
 	if err != nil {
 		return nil, err
@@ -120,100 +283,222 @@ func (l *DBLogger) getRequestStats(ctx context.Context, period string, windowSiz
 
 	// Get requests by status code
 	requestsByStatusCode := make(map[int]int)
-	query = "SELECT status_code, COUNT(*) FROM request_logs WHERE status_code IS NOT NULL "
-	if whereClause != "" {
-		query += "AND timestamp >= datetime(?)"
+	query = "SELECT status_code, COUNT(*) FROM request_logs" + where("status_code IS NOT NULL") + " GROUP BY status_code"
+	err = l.queryRows(ctx, query, args, func(rows *sql.Rows) error {
+		var statusCode, count int
+		if err := rows.Scan(&statusCode, &count); err != nil {
+			return err
+		}
+		requestsByStatusCode[statusCode] = count
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	query += " GROUP BY status_code"
 
-	rows, err := l.db.QueryContext(ctx, query, args...)
+	// Get requests by method
+	requestsByMethod := make(map[string]int)
+	query = "SELECT method, COUNT(*) FROM request_logs" + where() + " GROUP BY method"
+	err = l.queryRows(ctx, query, args, func(rows *sql.Rows) error {
+		var method string
+		var count int
+		if err := rows.Scan(&method, &count); err != nil {
+			return err
+		}
+		requestsByMethod[method] = count
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var statusCode int
+	// Get requests by topic
+	requestsByTopic := make(map[string]int)
+	query = "SELECT topic, COUNT(*) FROM request_logs" + where() + " GROUP BY topic ORDER BY COUNT(*) DESC LIMIT ?"
+	err = l.queryRows(ctx, query, append(slices.Clone(args), statsQuery.WindowSize), func(rows *sql.Rows) error {
+		var topic string
 		var count int
-		if err := rows.Scan(&statusCode, &count); err != nil {
+		if err := rows.Scan(&topic, &count); err != nil {
+			return err
+		}
+		requestsByTopic[topic] = count
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A range without start begins with the oldest request
+	if from.IsZero() {
+		var oldest sql.NullInt64
+		err = l.db.QueryRowContext(ctx, "SELECT CAST(strftime('%s', MIN(timestamp)) AS INTEGER) FROM request_logs").Scan(&oldest)
+		if err != nil {
 			return nil, err
 		}
-		requestsByStatusCode[statusCode] = count
+		from = now
+		if oldest.Valid {
+			from = time.Unix(oldest.Int64, 0)
+		}
+	}
+	if to.IsZero() {
+		to = now
 	}
 
-	// Get requests by topic
-	requestsByTopic := make(map[string]int)
-	query = "SELECT topic, COUNT(*) FROM request_logs "
-	if whereClause != "" {
-		query += "WHERE timestamp >= datetime(?)"
+	bucket := statsQuery.Bucket
+	if bucket == 0 {
+		bucket = statsBucketFor(to.Sub(from))
+	}
+	if to.Sub(from)/bucket >= maxStatsBuckets {
+		return nil, fmt.Errorf("%w: bucket %s splits the range into more than %d intervals", errInvalidStatsQuery, bucket, maxStatsBuckets)
+	}
+	bucketSeconds := int64(bucket / time.Second)
+
+	// Build the time series from buckets aligned to multiples of the bucket size
+	first := from.Unix() / bucketSeconds * bucketSeconds
+	var timeSeries []StatsBucket
+	for start := first; start < to.Unix() || start == first; start += bucketSeconds {
+		timeSeries = append(timeSeries, StatsBucket{Start: time.Unix(start, 0).UTC()})
+	}
+	bucketIndex := func(epoch int64) (int, bool) {
+		i := int((epoch - first) / bucketSeconds)
+		return i, epoch >= first && i < len(timeSeries)
 	}
-	query += " GROUP BY topic ORDER BY COUNT(*) DESC LIMIT ?"
 
-	args = append(args, windowSize)
-	rows, err = l.db.QueryContext(ctx, query, args...)
+	query = "SELECT CAST(strftime('%s', timestamp) AS INTEGER), COUNT(*), SUM(CASE WHEN " + failedRequestCondition +
+		" THEN 1 ELSE 0 END) FROM request_logs" + where() + " GROUP BY 1"
+	err = l.queryRows(ctx, query, args, func(rows *sql.Rows) error {
+		var epoch int64
+		var count, errorCount int
+		if err := rows.Scan(&epoch, &count, &errorCount); err != nil {
+			return err
+		}
+		if i, ok := bucketIndex(epoch); ok {
+			timeSeries[i].Requests += count
+			timeSeries[i].Errors += errorCount
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
+	// Percentiles are computed from the response times of the range
+	var latencies []int64
+	latenciesByTopic := make(map[string][]int64)
+	latenciesByBucket := make(map[int][]int64)
+	query = "SELECT topic, CAST(strftime('%s', timestamp) AS INTEGER), response_time FROM request_logs" +
+		where("response_time > 0") + " ORDER BY response_time"
+	err = l.queryRows(ctx, query, args, func(rows *sql.Rows) error {
 		var topic string
-		var count int
-		if err := rows.Scan(&topic, &count); err != nil {
-			return nil, err
+		var epoch, responseTime int64
+		if err := rows.Scan(&topic, &epoch, &responseTime); err != nil {
+			return err
+		}
+		latencies = append(latencies, responseTime)
+		if _, ok := requestsByTopic[topic]; ok {
+			latenciesByTopic[topic] = append(latenciesByTopic[topic], responseTime)
+		}
+		if i, ok := bucketIndex(epoch); ok {
+			latenciesByBucket[i] = append(latenciesByBucket[i], responseTime)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, bucketLatencies := range latenciesByBucket {
+		timeSeries[i].P95ResponseTime = percentile(bucketLatencies, 95)
+	}
+
+	latencyByTopic := make(map[string]TopicLatency)
+	for topic, topicLatencies := range latenciesByTopic {
+		var sum int64
+		for _, latency := range topicLatencies {
+			sum += latency
+		}
+		latencyByTopic[topic] = TopicLatency{
+			Requests:            len(topicLatencies),
+			AverageResponseTime: float64(sum) / float64(len(topicLatencies)),
+			MaxResponseTime:     topicLatencies[len(topicLatencies)-1],
+			LatencyPercentiles:  latencyPercentiles(topicLatencies),
 		}
-		requestsByTopic[topic] = count
 	}
 
 	return &RequestStats{
-		Timestamp:            now,
-		TotalRequests:        totalRequests,
-		SuccessfulRequests:   successfulRequests,
-		FailedRequests:       failedRequests,
-		AverageResponseTime:  avgResponseTime,
-		MinResponseTime:      minResponseTime.Int64,
-		MaxResponseTime:      maxResponseTime.Int64,
-		TimeoutRequests:      timeoutRequests,
-		RequestsByStatusCode: requestsByStatusCode,
-		RequestsByTopic:      requestsByTopic,
-		Period:               period,
-		WindowSize:           windowSize,
+		Timestamp:               now,
+		TotalRequests:           totalRequests,
+		SuccessfulRequests:      successfulRequests,
+		FailedRequests:          failedRequests,
+		AverageResponseTime:     avgResponseTime.Float64,
+		MinResponseTime:         minResponseTime.Int64,
+		MaxResponseTime:         maxResponseTime.Int64,
+		ResponseTimePercentiles: latencyPercentiles(latencies),
+		TimeoutRequests:         timeoutRequests,
+		NoSubscriberRequests:    noSubscriberRequests,
+		WebSocketConnections:    wsActive,
+		WebSocketSessions:       wsSessions,
+		WebSocketMessagesIn:     wsIn,
+		WebSocketMessagesOut:    wsOut,
+		RequestsByStatusCode:    requestsByStatusCode,
+		RequestsByTopic:         requestsByTopic,
+		RequestsByMethod:        requestsByMethod,
+		LatencyByTopic:          latencyByTopic,
+		TimeSeries:              timeSeries,
+		Period:                  period,
+		From:                    from,
+		To:                      to,
+		BucketSeconds:           bucketSeconds,
+		WindowSize:              statsQuery.WindowSize,
 	}, nil
 }
 
-// statsHandler provides statistics about logged requests
-func (ps *ProxyServer) statsHandler(w http.ResponseWriter, r *http.Request) {
-	if ps.dbLogger == nil || !ps.dbLogger.enabled {
-		http.Error(w, "Logging not enabled", http.StatusNotFound)
-		return
+// queryRows runs a query and calls scan for every row
+func (l *DBLogger) queryRows(ctx context.Context, query string, args []interface{}, scan func(rows *sql.Rows) error) error {
+	rows, err := l.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
 	}
+	defer rows.Close()
 
-	// Parse query parameters
-	period := r.URL.Query().Get("period")
-	if period == "" {
-		period = "hour" // Default to last hour
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
 	}
+	return rows.Err()
+}
 
-	windowSizeStr := r.URL.Query().Get("window_size")
-	windowSize := 10 // Default to top 10
-	if windowSizeStr != "" {
-		if parsed, err := strconv.Atoi(windowSizeStr); err == nil && parsed > 0 {
-			windowSize = parsed
+// writeStats writes the statistics selected by a stats query as JSON
+func writeStats(w http.ResponseWriter, r *http.Request, dbLogger *DBLogger, defaultPeriod string) {
+	statsQuery, err := parseStatsQuery(r, defaultPeriod)
+	if err == nil {
+		var stats *RequestStats
+		if stats, err = dbLogger.getRequestStats(r.Context(), statsQuery); err == nil {
+			// Return as JSON
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(stats); err != nil {
+				log.Error().Err(err).Msg("Error encoding statistics")
+				http.Error(w, "Error encoding statistics", http.StatusInternalServerError)
+			}
+			return
 		}
 	}
 
-	// Get statistics from database
-	stats, err := ps.dbLogger.getRequestStats(r.Context(), period, windowSize)
-	if err != nil {
-		log.Error().Err(err).Str("period", period).Msg("Error retrieving request statistics")
-		http.Error(w, "Error retrieving statistics", http.StatusInternalServerError)
+	if errors.Is(err, errInvalidStatsQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	log.Error().Err(err).Str("period", statsQuery.Period).Msg("Error retrieving request statistics")
+	http.Error(w, "Error retrieving statistics", http.StatusInternalServerError)
+}
 
-	// Return as JSON
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(stats); err != nil {
-		log.Error().Err(err).Msg("Error encoding stats")
-		http.Error(w, "Error encoding stats", http.StatusInternalServerError)
+// statsHandler provides statistics about logged requests
+func (ps *ProxyServer) statsHandler(w http.ResponseWriter, r *http.Request) {
+	if ps.dbLogger == nil || !ps.dbLogger.enabled {
+		http.Error(w, "Logging not enabled", http.StatusNotFound)
+		return
 	}
+
+	writeStats(w, r, ps.dbLogger, "hour") // Default to last hour
 }
@@ -107,12 +107,15 @@ const statsHTMLTemplate = `
         button:hover {
             background-color: #3367d6;
         }
-        select {
+        select, input[type="datetime-local"] {
             padding: 8px;
             border: 1px solid #ddd;
             border-radius: 4px;
             margin-right: 10px;
         }
+        #custom-range {
+            display: none;
+        }
         #auto-refresh-container {
             margin-left: 20px;
         }
@@ -166,6 +169,7 @@ const statsHTMLTemplate = `
         <a href="/dashboard">Home</a>
         <a href="/dashboard/stats">Statistics</a>
         <a href="/dashboard/logs">Logs</a>
+        <a href="/dashboard/callbacks">Callbacks</a>
     </nav>
     
     <div class="container">
@@ -178,6 +182,26 @@ const statsHTMLTemplate = `
                     <option value="week">Last 7 Days</option>
                     <option value="month">Last 30 Days</option>
                     <option value="all">All Time</option>
+                    <option value="custom">Custom Range</option>
+                </select>
+            </div>
+
+            <div id="custom-range">
+                <label for="from">From:</label>
+                <input type="datetime-local" id="from">
+                <label for="to">To:</label>
+                <input type="datetime-local" id="to">
+            </div>
+
+            <div>
+                <label for="bucket">Interval:</label>
+                <select id="bucket">
+                    <option value="">Auto</option>
+                    <option value="1m">1 minute</option>
+                    <option value="5m">5 minutes</option>
+                    <option value="15m">15 minutes</option>
+                    <option value="1h">1 hour</option>
+                    <option value="24h">1 day</option>
                 </select>
             </div>
             
@@ -207,6 +231,7 @@ const statsHTMLTemplate = `
         let autoRefreshInterval;
         let statusChart;
         let topicsChart;
+        let seriesChart;
         
         // Function to format numbers with commas
         function formatNumber(num) {
@@ -220,8 +245,72 @@ const statsHTMLTemplate = `
             return (ms / 1000).toFixed(2) + " sec";
         }
         
-        // Function to create or update charts
+        // Function to format the start of a time series bucket
+        function formatBucket(start, bucketSeconds) {
+            const date = new Date(start);
+            if (bucketSeconds >= 86400) return date.toLocaleDateString();
+            return date.toLocaleString([], {month: 'numeric', day: 'numeric', hour: '2-digit', minute: '2-digit'});
+        }
+
+        // Function to create the charts, replacing those of the previous fetch
         function updateCharts(data) {
+            [statusChart, topicsChart, seriesChart].forEach(chart => chart && chart.destroy());
+            statusChart = topicsChart = seriesChart = undefined;
+
+            // Time series chart
+            const series = data.time_series || [];
+            if (series.length > 0) {
+                const ctx = document.getElementById('series-chart');
+                seriesChart = new Chart(ctx, {
+                    type: 'bar',
+                    data: {
+                        labels: series.map(bucket => formatBucket(bucket.start, data.bucket_seconds)),
+                        datasets: [{
+                            label: 'Requests',
+                            data: series.map(bucket => bucket.requests),
+                            backgroundColor: '#4285f4',
+                            yAxisID: 'y'
+                        }, {
+                            label: 'Errors',
+                            data: series.map(bucket => bucket.errors),
+                            backgroundColor: '#f44336',
+                            yAxisID: 'y'
+                        }, {
+                            label: 'p95 Response Time (ms)',
+                            type: 'line',
+                            data: series.map(bucket => bucket.p95_response_time_ms),
+                            borderColor: '#ff9800',
+                            backgroundColor: '#ff9800',
+                            pointRadius: 0,
+                            yAxisID: 'latency'
+                        }]
+                    },
+                    options: {
+                        responsive: true,
+                        maintainAspectRatio: false,
+                        plugins: {
+                            title: {
+                                display: true,
+                                text: 'Requests, Errors and p95 Response Time'
+                            }
+                        },
+                        scales: {
+                            y: {
+                                beginAtZero: true,
+                                position: 'left'
+                            },
+                            latency: {
+                                beginAtZero: true,
+                                position: 'right',
+                                grid: {
+                                    drawOnChartArea: false
+                                }
+                            }
+                        }
+                    }
+                });
+            }
+
             // Status code chart
             const statusCodes = Object.keys(data.requests_by_status_code || {});
             const statusCounts = statusCodes.map(code => data.requests_by_status_code[code]);
@@ -233,12 +322,7 @@ const statsHTMLTemplate = `
                 return '#9e9e9e';
             });
             
-            if (statusChart) {
-                statusChart.data.labels = statusCodes;
-                statusChart.data.datasets[0].data = statusCounts;
-                statusChart.data.datasets[0].backgroundColor = statusColors;
-                statusChart.update();
-            } else if (statusCodes.length > 0) {
+            if (statusCodes.length > 0) {
                 const ctx = document.getElementById('status-chart');
                 statusChart = new Chart(ctx, {
                     type: 'bar',
@@ -275,11 +359,7 @@ const statsHTMLTemplate = `
             const topics = Object.keys(data.requests_by_topic || {});
             const topicCounts = topics.map(topic => data.requests_by_topic[topic]);
             
-            if (topicsChart) {
-                topicsChart.data.labels = topics;
-                topicsChart.data.datasets[0].data = topicCounts;
-                topicsChart.update();
-            } else if (topics.length > 0) {
+            if (topics.length > 0) {
                 const ctx = document.getElementById('topics-chart');
                 topicsChart = new Chart(ctx, {
                     type: 'pie',
@@ -311,17 +391,50 @@ const statsHTMLTemplate = `
             }
         }
         
+        // Function to build the query of the selected range and interval
+        function buildQuery(period) {
+            const params = new URLSearchParams({window_size: 10});
+            if (period === 'custom') {
+                const from = document.getElementById('from').value;
+                const to = document.getElementById('to').value;
+                if (!from) {
+                    throw new Error('Select the start of the range');
+                }
+                params.set('from', new Date(from).toISOString().replace(/\.\d+Z$/, 'Z'));
+                if (to) {
+                    params.set('to', new Date(to).toISOString().replace(/\.\d+Z$/, 'Z'));
+                }
+            } else {
+                params.set('period', period);
+            }
+            const bucket = document.getElementById('bucket').value;
+            if (bucket) {
+                params.set('bucket', bucket);
+            }
+            return params.toString();
+        }
+
         // Function to fetch and display statistics
         function fetchStats() {
             const period = document.getElementById('period').value;
             const statsContainer = document.getElementById('stats-container');
             
+            let query;
+            try {
+                query = buildQuery(period);
+            } catch (error) {
+                statsContainer.innerHTML = '<div class="error">' + error.message + '</div>';
+                return;
+            }
+
             statsContainer.innerHTML = '<div class="loading">Loading statistics...</div>';
             
-            fetch('/dashboard/api/stats?period=' + period + '&window_size=10')
+            fetch('/dashboard/api/stats?' + query)
                 .then(response => {
                     if (!response.ok) {
-                        throw new Error('Failed to fetch statistics');
+                        return response.text().then(text => {
+                            throw new Error(response.status === 400 ? text : 'Failed to fetch statistics');
+                        });
                     }
                     return response.json();
                 })
@@ -361,6 +474,12 @@ const statsHTMLTemplate = `
                     content += '<div class="stat-label">' + getPeriodLabel(period) + '</div>';
                     content += '</div>';
                     
+                    content += '<div class="stat-card">';
+                    content += '<div class="stat-label">No Subscribers</div>';
+                    content += '<div class="stat-value">' + formatNumber(data.no_subscriber_requests || 0) + '</div>';
+                    content += '<div class="stat-label">' + getPeriodLabel(period) + '</div>';
+                    content += '</div>';
+                    
                     content += '</div>'; // End of stats-grid
                     content += '</div>'; // End of overview card
                     
@@ -371,25 +490,66 @@ const statsHTMLTemplate = `
                     
                     content += '<div class="stat-card">';
                     content += '<div class="stat-label">Average Response Time</div>';
-                    content += '<div class="stat-value">' + (data.average_response_time ? Math.round(data.average_response_time) : 0) + ' ms</div>';
+                    content += '<div class="stat-value">' + Math.round(data.average_response_time_ms || 0) + ' ms</div>';
                     content += '<div class="stat-label">' + getPeriodLabel(period) + '</div>';
                     content += '</div>';
                     
                     content += '<div class="stat-card">';
                     content += '<div class="stat-label">Min Response Time</div>';
-                    content += '<div class="stat-value">' + (data.min_response_time || 0) + ' ms</div>';
+                    content += '<div class="stat-value">' + (data.min_response_time_ms || 0) + ' ms</div>';
                     content += '<div class="stat-label">' + getPeriodLabel(period) + '</div>';
                     content += '</div>';
                     
                     content += '<div class="stat-card">';
                     content += '<div class="stat-label">Max Response Time</div>';
-                    content += '<div class="stat-value">' + (data.max_response_time || 0) + ' ms</div>';
+                    content += '<div class="stat-value">' + (data.max_response_time_ms || 0) + ' ms</div>';
                     content += '<div class="stat-label">' + getPeriodLabel(period) + '</div>';
                     content += '</div>';
                     
+                    const percentiles = data.response_time_percentiles || {};
+                    ['p50', 'p90', 'p95', 'p99'].forEach(p => {
+                        content += '<div class="stat-card">';
+                        content += '<div class="stat-label">' + p + ' Response Time</div>';
+                        content += '<div class="stat-value">' + (percentiles[p + '_ms'] || 0) + ' ms</div>';
+                        content += '<div class="stat-label">' + getPeriodLabel(period) + '</div>';
+                        content += '</div>';
+                    });
+                    
                     content += '</div>'; // End of stats-grid
                     content += '</div>'; // End of performance card
                     
+                    // Trends section
+                    content += '<div class="card">';
+                    content += '<h2>Trends</h2>';
+                    content += '<div class="chart-container"><canvas id="series-chart"></canvas></div>';
+                    content += '</div>'; // End of trends card
+                    
+                    // WebSocket section
+                    content += '<div class="card">';
+                    content += '<h2>WebSocket Connections</h2>';
+                    content += '<div class="stats-grid">';
+                    
+                    content += '<div class="stat-card">';
+                    content += '<div class="stat-label">Open Connections</div>';
+                    content += '<div class="stat-value">' + formatNumber(data.websocket_connections || 0) + '</div>';
+                    content += '<div class="stat-label">Now</div>';
+                    content += '</div>';
+                    
+                    content += '<div class="stat-card">';
+                    content += '<div class="stat-label">Sessions</div>';
+                    content += '<div class="stat-value">' + formatNumber(data.websocket_sessions || 0) + '</div>';
+                    content += '<div class="stat-label">' + getPeriodLabel(period) + '</div>';
+                    content += '</div>';
+                    
+                    content += '<div class="stat-card">';
+                    content += '<div class="stat-label">Frames In / Out</div>';
+                    content += '<div class="stat-value">' + formatNumber(data.websocket_messages_in || 0) + ' / ' + formatNumber(data.websocket_messages_out || 0) + '</div>';
+                    content += '<div class="stat-label">Closed sessions, ' + getPeriodLabel(period) + '</div>';
+                    content += '</div>';
+                    
+                    content += '</div>'; // End of stats-grid
+                    content += '</div>'; // End of WebSocket card
+                    
                     // Charts section
                     content += '<div class="card">';
                     content += '<h2>Request Distribution</h2>';
@@ -454,6 +614,54 @@ const statsHTMLTemplate = `
                     content += '</table>';
                     content += '</div>'; // End of topics card
                     
+                    // Topic latency table
+                    content += '<div class="card">';
+                    content += '<h2>Response Time by Topic</h2>';
+                    content += '<table>';
+                    content += '<thead><tr><th>Topic</th><th>Responses</th><th>Average</th><th>p50</th><th>p90</th><th>p95</th><th>p99</th><th>Max</th></tr></thead>';
+                    content += '<tbody>';
+                    
+                    Object.keys(data.latency_by_topic || {}).sort().forEach(topic => {
+                        const latency = data.latency_by_topic[topic];
+                        content += '<tr>';
+                        content += '<td>' + topic + '</td>';
+                        content += '<td>' + formatNumber(latency.requests) + '</td>';
+                        content += '<td>' + formatTime(latency.average_response_time_ms) + '</td>';
+                        content += '<td>' + formatTime(latency.p50_ms) + '</td>';
+                        content += '<td>' + formatTime(latency.p90_ms) + '</td>';
+                        content += '<td>' + formatTime(latency.p95_ms) + '</td>';
+                        content += '<td>' + formatTime(latency.p99_ms) + '</td>';
+                        content += '<td>' + formatTime(latency.max_response_time_ms) + '</td>';
+                        content += '</tr>';
+                    });
+                    
+                    content += '</tbody>';
+                    content += '</table>';
+                    content += '</div>'; // End of topic latency card
+                    
+                    // Methods table
+                    content += '<div class="card">';
+                    content += '<h2>Requests by Method</h2>';
+                    content += '<table>';
+                    content += '<thead><tr><th>Method</th><th>Count</th><th>Percentage</th></tr></thead>';
+                    content += '<tbody>';
+                    
+                    Object.keys(data.requests_by_method || {}).sort().forEach(method => {
+                        const count = data.requests_by_method[method];
+                        const percentage = data.total_requests > 0 
+                            ? ((count / data.total_requests) * 100).toFixed(1) + '%' 
+                            : '0%';
+                        content += '<tr>';
+                        content += '<td>' + method + '</td>';
+                        content += '<td>' + count + '</td>';
+                        content += '<td>' + percentage + '</td>';
+                        content += '</tr>';
+                    });
+                    
+                    content += '</tbody>';
+                    content += '</table>';
+                    content += '</div>'; // End of methods card
+                    
                     // Update the container
                     statsContainer.innerHTML = content;
                     
@@ -473,6 +681,7 @@ const statsHTMLTemplate = `
                 case 'week': return 'Last 7 Days';
                 case 'month': return 'Last 30 Days';
                 case 'all': return 'All Time';
+                case 'custom': return 'Selected Range';
                 default: return period;
             }
         }
@@ -485,8 +694,17 @@ const statsHTMLTemplate = `
             // Refresh button
             document.getElementById('refresh-btn').addEventListener('click', fetchStats);
             
-            // Period change
-            document.getElementById('period').addEventListener('change', fetchStats);
+            // Period change, the custom range is fetched once its start is selected
+            document.getElementById('period').addEventListener('change', function() {
+                const custom = this.value === 'custom';
+                document.getElementById('custom-range').style.display = custom ? 'block' : 'none';
+                if (!custom || document.getElementById('from').value) {
+                    fetchStats();
+                }
+            });
+            document.getElementById('from').addEventListener('change', fetchStats);
+            document.getElementById('to').addEventListener('change', fetchStats);
+            document.getElementById('bucket').addEventListener('change', fetchStats);
             
             // Auto refresh
             const autoRefreshCheckbox = document.getElementById('auto-refresh');
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// StreamChunk represents one message of a streamed reply. Status, headers and
+// content type are only honoured on the chunk with sequence number 0.
+type StreamChunk struct {
+	Seq         int                    `json:"seq"`
+	End         bool                   `json:"end,omitempty"`
+	Event       string                 `json:"event,omitempty"` // SSE event name
+	Status      int                    `json:"status,omitempty"`
+	Headers     map[string]interface{} `json:"headers,omitempty"`
+	ContentType string                 `json:"content_type,omitempty"`
+	Body        interface{}            `json:"body,omitempty"`
+}
+
+// parseStreamChunk parses a reply payload as a stream chunk. Replies without a
+// numeric "seq" field are not part of a stream.
+func parseStreamChunk(payload string) (*StreamChunk, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(payload), &fields); err != nil {
+		return nil, false
+	}
+	seq, ok := fields["seq"]
+	if !ok {
+		return nil, false
+	}
+	var n int
+	if err := json.Unmarshal(seq, &n); err != nil {
+		return nil, false
+	}
+
+	var chunk StreamChunk
+	if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+		return nil, false
+	}
+	return &chunk, true
+}
+
+// acceptsEventStream reports whether the client asked for Server-Sent Events
+func acceptsEventStream(r *http.Request) bool {
+	for _, value := range r.Header.Values("Accept") {
+		if strings.Contains(value, "text/event-stream") {
+			return true
+		}
+	}
+	return false
+}
+
+// streamResponse forwards a streamed reply to the client, either as Server-Sent
+// Events or as a chunked HTTP response, starting with the given first chunk
+func (ps *ProxyServer) streamResponse(w http.ResponseWriter, r *http.Request, logger zerolog.Logger, requestID, topic string,
+	first *StreamChunk, waiter *ReplyWaiter, startTime time.Time) {
+
+	sse := acceptsEventStream(r)
+	logger = logger.With().Bool("sse", sse).Logger()
+	logger.Debug().Msg("Streaming response to client")
+
+	// Status and headers come from the first chunk and are validated like a regular reply
+	head := &Response{Status: first.Status, Headers: first.Headers, ContentType: first.ContentType}
+	if err := validateResponse(head); err != nil {
+		logger.Error().Err(err).Msg("Invalid stream header")
+		if ps.dbLogger != nil && ps.dbLogger.enabled {
+			ps.dbLogger.LogResponse(requestID, http.StatusInternalServerError, nil, time.Since(startTime), err)
+		}
+		http.Error(w, fmt.Sprintf("error parsing response: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	contentType := head.ContentType
+	switch {
+	case sse:
+		contentType = "text/event-stream"
+	case contentType == "":
+		if _, ok := first.Body.(string); ok {
+			contentType = "text/plain; charset=utf-8"
+		} else {
+			contentType = "application/x-ndjson"
+		}
+	}
+
+	// The stream may outlive the server write timeout
+	maxDuration := time.Duration(ps.cfg().StreamingMaxDuration) * time.Second
+	deadline := startTime.Add(maxDuration)
+	controller := http.NewResponseController(w)
+	if err := controller.SetWriteDeadline(deadline); err != nil {
+		logger.Debug().Err(err).Msg("Could not extend write deadline for stream")
+	}
+
+	writeResponse(w, &Response{Status: head.Status, Headers: head.Headers}, contentType, nil)
+	if sse {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	controller.Flush()
+
+	// Chunks are written in sequence order, early arrivals wait in pending
+	pending := map[int]*StreamChunk{first.Seq: first}
+	nextSeq := 0
+	var assembled []interface{}
+	var text strings.Builder
+	textOnly := true
+	var streamErr error
+
+	idleTimeout := time.Duration(ps.cfg().StreamingIdleTimeout) * time.Second
+	idleTimer := time.NewTimer(idleTimeout)
+	defer idleTimer.Stop()
+	totalTimer := time.NewTimer(time.Until(deadline))
+	defer totalTimer.Stop()
+
+	done := false
+	for !done {
+		// Write all chunks that are next in sequence
+		for chunk, ok := pending[nextSeq]; ok; chunk, ok = pending[nextSeq] {
+			delete(pending, nextSeq)
+			nextSeq++
+
+			if chunk.Body != nil {
+				if err := writeStreamChunk(w, chunk, sse); err != nil {
+					streamErr = fmt.Errorf("error writing stream chunk: %w", err)
+					done = true
+					break
+				}
+				if s, ok := chunk.Body.(string); ok && textOnly {
+					text.WriteString(s)
+				} else {
+					textOnly = false
+				}
+				assembled = append(assembled, chunk.Body)
+			}
+
+			if chunk.End {
+				done = true
+				break
+			}
+		}
+		controller.Flush()
+		if done {
+			break
+		}
+
+		select {
+		case payload := <-waiter.Replies():
+			chunk, ok := parseStreamChunk(payload)
+			if !ok {
+				logger.Warn().Str("payload", truncateString(payload, 200)).Msg("Ignoring non-stream reply during stream")
+				continue
+			}
+			if chunk.Seq < nextSeq {
+				logger.Debug().Int("seq", chunk.Seq).Msg("Ignoring duplicate stream chunk")
+				continue
+			}
+			pending[chunk.Seq] = chunk
+
+			if !idleTimer.Stop() {
+				<-idleTimer.C
+			}
+			idleTimer.Reset(idleTimeout)
+
+		case <-idleTimer.C:
+			streamErr = fmt.Errorf("stream idle timeout after %d seconds", ps.cfg().StreamingIdleTimeout)
+			ps.metrics.Timeout(topic, timeoutStreamIdle)
+			done = true
+
+		case <-totalTimer.C:
+			streamErr = fmt.Errorf("stream exceeded maximum duration of %d seconds", ps.cfg().StreamingMaxDuration)
+			ps.metrics.Timeout(topic, timeoutStreamDuration)
+			done = true
+
+		case <-r.Context().Done():
+			streamErr = errClientClosedRequest
+			done = true
+		}
+	}
+
+	statusCode := head.Status
+	if streamErr != nil {
+		logger.Error().Err(streamErr).Int("chunks", nextSeq).Msg("Stream ended abnormally")
+		if errors.Is(streamErr, errClientClosedRequest) {
+			statusCode = StatusClientClosedRequest
+		} else if sse {
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", streamErr.Error())
+			controller.Flush()
+		}
+		ps.cancelRequest(logger, topic, requestID, streamErr)
+	} else {
+		logger.Debug().Int("chunks", nextSeq).Msg("Stream completed")
+	}
+
+	// Log the assembled response
+	if ps.dbLogger != nil && ps.dbLogger.enabled {
+		var body interface{} = assembled
+		if textOnly && len(assembled) > 0 {
+			body = text.String()
+		}
+		ps.dbLogger.LogResponse(requestID, statusCode, body, time.Since(startTime), streamErr)
+	}
+}
+
+// writeStreamChunk writes a single chunk body as an SSE event or a raw chunk
+func writeStreamChunk(w http.ResponseWriter, chunk *StreamChunk, sse bool) error {
+	data, isText := chunk.Body.(string)
+	if !isText {
+		encoded, err := json.Marshal(chunk.Body)
+		if err != nil {
+			return err
+		}
+		data = string(encoded)
+	}
+
+	if !sse {
+		if !isText {
+			data += "\n"
+		}
+		_, err := w.Write([]byte(data))
+		return err
+	}
+
+	var event strings.Builder
+	event.WriteString("id: " + strconv.Itoa(chunk.Seq) + "\n")
+	if chunk.Event != "" {
+		event.WriteString("event: " + strings.ReplaceAll(chunk.Event, "\n", " ") + "\n")
+	}
+	for _, line := range strings.Split(data, "\n") {
+		event.WriteString("data: " + line + "\n")
+	}
+	event.WriteString("\n")
+
+	_, err := w.Write([]byte(event.String()))
+	return err
+}
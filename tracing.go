@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span exporters selected with TRACING_EXPORTER
+const (
+	TracingExporterNone   = ""
+	TracingExporterOTLP   = "otlp"
+	TracingExporterStdout = "stdout"
+)
+
+// tracer creates the spans of the proxy. It uses the global tracer provider, which
+// doesn't record spans unless tracing is set up.
+var tracer = otel.Tracer("github.com/sistemica/async-proxy-redis")
+
+// setupTracing installs the W3C trace context propagator and, if an exporter is
+// configured, a tracer provider exporting the spans of the proxy. The returned
+// function flushes pending spans and must be called before the process exits.
+func setupTracing(config Config) (func(), error) {
+	// Trace context is passed on to backends even if the proxy doesn't record spans
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.TracingExporter {
+	case TracingExporterNone:
+		return func() {}, nil
+	case TracingExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case TracingExporterOTLP:
+		// Without an endpoint, the OTEL_EXPORTER_OTLP_* environment variables apply
+		var options []otlptracehttp.Option
+		if config.TracingEndpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(config.TracingEndpoint))
+		}
+		exporter, err = otlptracehttp.New(context.Background(), options...)
+	default:
+		err = fmt.Errorf("unknown exporter %q", config.TracingExporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create span exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(config.TracingServiceName),
+		semconv.ServiceInstanceID(config.InstanceID),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tracing resource: %w", err)
+	}
+
+	// The sampler can be chosen with OTEL_TRACES_SAMPLER, it samples all traces by default
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	log.Info().Str("exporter", config.TracingExporter).Str("service", config.TracingServiceName).Msg("Tracing enabled")
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			log.Error().Err(err).Msg("Failed to flush spans")
+		}
+	}, nil
+}
+
+// headerCarrier carries trace context in the header map of a message or a reply.
+// Keys are matched case-insensitively, since reply headers may use any case.
+type headerCarrier map[string]interface{}
+
+// Get returns the value of a key, or the first value if it has several
+func (c headerCarrier) Get(key string) string {
+	for k, value := range c {
+		if !strings.EqualFold(k, key) {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			return v
+		case []string:
+			if len(v) > 0 {
+				return v[0]
+			}
+		case []interface{}:
+			if len(v) > 0 {
+				s, _ := v[0].(string)
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// Set replaces the value of a key, including values whose key differs in case
+func (c headerCarrier) Set(key, value string) {
+	for k := range c {
+		if strings.EqualFold(k, key) {
+			delete(c, k)
+		}
+	}
+	c[key] = value
+}
+
+// Keys returns the keys of the header map
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// injectTraceContext adds the trace context of ctx to the headers of a message
+// as "traceparent" and "tracestate", replacing those copied from the HTTP request
+func injectTraceContext(ctx context.Context, header map[string]interface{}) {
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(header))
+}
+
+// extractRequestTraceContext returns a context carrying the trace context of an HTTP request
+func extractRequestTraceContext(r *http.Request) context.Context {
+	return otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+}
+
+// linkReplyTraceContext links a span to the backend span a reply came from, if
+// the backend sent its trace context in the reply headers
+func linkReplyTraceContext(span trace.Span, headers map[string]interface{}) {
+	if len(headers) == 0 {
+		return
+	}
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), headerCarrier(headers))
+	if backend := trace.SpanContextFromContext(ctx); backend.IsValid() {
+		span.AddLink(trace.Link{SpanContext: backend})
+	}
+}
+
+// endRequestSpan records the response status of a request and ends its span
+func endRequestSpan(span trace.Span, status int) {
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
+// endSpan records an error, if any, and ends a span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -2,11 +2,15 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 )
 
@@ -18,6 +22,21 @@ type Config struct {
 	RedisDB       int
 	RedisPoolSize int
 
+	// Redis deployment, authentication and TLS settings
+	RedisMode             string // "standalone", "sentinel" or "cluster"
+	RedisURL              string // redis:// or rediss:// URLs, comma-separated, used instead of RedisAddr
+	RedisUsername         string // ACL username
+	RedisSentinelMaster   string // Name of the master monitored by Sentinel
+	RedisSentinelUsername string // ACL username for the Sentinels
+	RedisSentinelPassword string // Password for the Sentinels
+	RedisShardedPubSub    bool   // Publish with SPUBLISH in a cluster, where available
+	RedisTLS              bool   // Connect with TLS, also enabled by a rediss:// URL
+	RedisTLSCAFile        string // CA certificates to verify the server, empty uses the system roots
+	RedisTLSCertFile      string // Client certificate for mutual TLS
+	RedisTLSKeyFile       string // Key of the client certificate
+	RedisTLSServerName    string // Server name to verify, empty uses the host name of the address
+	RedisTLSInsecure      bool   // Skip verification of the server certificate
+
 	// HTTP server settings
 	Port            int
 	ReadTimeout     time.Duration
@@ -30,6 +49,76 @@ type Config struct {
 	FixedTopic               string // If set, all messages go to this topic
 	RespondImmediatelyStatus int    // If set, respond immediately with this status code
 	ResponseTimeout          int    // Timeout in seconds for waiting for a response
+	StreamingIdleTimeout     int    // Timeout in seconds between chunks of a streamed response
+	StreamingMaxDuration     int    // Maximum duration in seconds of a streamed response
+	CancelTopicSuffix        string // Suffix of the topic cancellations are published to, empty disables them
+
+	// Configuration reload settings
+	ConfigReloadInterval int // Seconds between checks of the config and routes files for changes, 0 disables them
+
+	// Routing table settings
+	RoutesFile           string      // Path to a JSON file mapping routes to topics, empty uses FixedTopic or path-based topics
+	UnmatchedRouteStatus int         // Status code for requests matching no route
+	Routes               *RouteTable // Loaded from RoutesFile or the config file, nil without a routing table
+
+	// Authentication settings
+	AuthFile string      // Path to a JSON file with the API keys, JWT and webhook settings, empty accepts all requests
+	Auth     *AuthConfig // Loaded from AuthFile, nil without authentication
+
+	// Broker settings
+	Broker           string // Broker connecting the proxy to backends: "redis" or "memory"
+	MemoryBrokerEcho bool   // Answer requests with the built-in echo backend when using the memory broker
+	HealthPath       string // Path of the health check endpoint, empty disables it
+
+	// Metrics settings
+	MetricsPath      string // Path of the Prometheus metrics endpoint, empty disables it
+	MetricsMaxTopics int    // Maximum number of topic labels, further topics are counted as "other"
+
+	// Tracing settings
+	TracingExporter    string // Span exporter: "otlp" or "stdout", empty disables tracing
+	TracingEndpoint    string // OTLP/HTTP endpoint URL, empty uses the OTEL_EXPORTER_OTLP_* variables
+	TracingServiceName string // Service name of the exported spans
+
+	// NATS settings
+	NATSURL       string // NATS server URLs, comma-separated
+	NATSCredsFile string // Credentials file for authentication
+	NATSJobBucket string // JetStream key-value bucket holding job state
+
+	// Transport settings
+	Transport    string // How requests reach backends: "pubsub", "streams" or "queue"
+	StreamMaxLen int64  // Approximate maximum length of request streams, 0 disables trimming
+
+	// Asynchronous job settings
+	AsyncJobs    bool   // Handle all requests as jobs, otherwise only with "Prefer: respond-async"
+	JobsPath     string // Path prefix for polling job status
+	JobTimeout   int    // Timeout in seconds for waiting for a job reply
+	JobTTL       int    // Seconds job state is kept in Redis
+	JobKeyPrefix string // Prefix of the Redis keys holding job state
+
+	// Callback delivery settings for immediate responses
+	CallbackHeader         string   // Request header carrying the callback URL
+	CallbackSecret         string   // If set, callbacks are signed with HMAC-SHA256
+	CallbackTimeout        int      // Timeout in seconds for waiting for the reply to deliver
+	CallbackRequestTimeout int      // Timeout in seconds for a single delivery attempt
+	CallbackMaxAttempts    int      // Maximum number of delivery attempts
+	CallbackRetryBaseMs    int      // Initial delay between attempts, doubled after each attempt
+	CallbackAllowedHosts   []string // If set, callbacks are only delivered to these hosts
+
+	// WebSocket bridge settings
+	WebSocketPath         string   // Path prefix of the WebSocket endpoint, empty disables it
+	WebSocketPingInterval int      // Seconds between pings sent to WebSocket clients
+	WebSocketPongTimeout  int      // Seconds without any frame from a client before it is disconnected
+	WebSocketMaxFrameSize int64    // Maximum size in bytes of an inbound WebSocket frame
+	WebSocketOrigins      []string // Origins allowed to connect, empty only allows same-origin clients
+
+	// Behavior when a pub/sub message reaches no backend
+	FailOnNoSubscribers bool // Respond with 503 instead of waiting for the response timeout
+	NoSubscriberGraceMs int  // Keep retrying the publish for this long before failing
+
+	// Reply routing settings
+	InstanceID  string // Identifies this proxy instance in reply topics
+	ReplyPrefix string // Prefix of the reply topics this instance subscribes to
+	ReplyShards int    // Number of pattern subscriptions replies are spread over
 
 	// Debug mode
 	Debug    bool
@@ -48,7 +137,10 @@ type Message struct {
 
 // Response represents the expected response format from Redis
 type Response struct {
-	Body interface{} `json:"body"`
+	Status      int                    `json:"status,omitempty"`       // HTTP status code, defaults to 200
+	Headers     map[string]interface{} `json:"headers,omitempty"`      // Values may be a string or a list of strings
+	ContentType string                 `json:"content_type,omitempty"` // Overrides any Content-Type in Headers
+	Body        interface{}            `json:"body"`
 }
 
 // Result represents the result of a request processing
@@ -62,19 +154,74 @@ type Result struct {
 func LoadConfigFromEnv() Config {
 	// Default configuration
 	config := Config{
-		RedisAddr:       getEnv("REDIS_ADDR", "localhost:6379"),
-		RedisPassword:   getEnv("REDIS_PASSWORD", ""),
-		RedisPoolSize:   getEnvAsInt("REDIS_POOL_SIZE", 10),
-		Port:            getEnvAsInt("PORT", 8080),
-		ReadTimeout:     time.Duration(getEnvAsInt("HTTP_READ_TIMEOUT", 30)) * time.Second,
-		WriteTimeout:    time.Duration(getEnvAsInt("HTTP_WRITE_TIMEOUT", 30)) * time.Second,
-		IdleTimeout:     time.Duration(getEnvAsInt("HTTP_IDLE_TIMEOUT", 60)) * time.Second,
-		MaxHeaderBytes:  getEnvAsInt("HTTP_MAX_HEADER_BYTES", 1<<20), // 1MB
-		ShutdownTimeout: getEnvAsInt("SHUTDOWN_TIMEOUT", 30),
-		ResponseTimeout: getEnvAsInt("RESPONSE_TIMEOUT", 30),
-		LogLevel:        getLogLevel(getEnv("LOG_LEVEL", "info")),
-		DBLogPath:       getEnv("DB_LOG_PATH", ""),
-		DBMaxEntries:    getEnvAsInt("DB_MAX_ENTRIES", 0),
+		RedisAddr:              getEnv("REDIS_ADDR", "localhost:6379"),
+		RedisPassword:          getEnv("REDIS_PASSWORD", ""),
+		RedisPoolSize:          getEnvAsInt("REDIS_POOL_SIZE", 10),
+		RedisMode:              strings.ToLower(getEnv("REDIS_MODE", RedisModeStandalone)),
+		RedisURL:               getEnv("REDIS_URL", ""),
+		RedisUsername:          getEnv("REDIS_USERNAME", ""),
+		RedisSentinelMaster:    getEnv("REDIS_SENTINEL_MASTER", ""),
+		RedisSentinelUsername:  getEnv("REDIS_SENTINEL_USERNAME", ""),
+		RedisSentinelPassword:  getEnv("REDIS_SENTINEL_PASSWORD", ""),
+		RedisShardedPubSub:     getEnvAsBool("REDIS_SHARDED_PUBSUB", false),
+		RedisTLS:               getEnvAsBool("REDIS_TLS", false),
+		RedisTLSCAFile:         getEnv("REDIS_TLS_CA_FILE", ""),
+		RedisTLSCertFile:       getEnv("REDIS_TLS_CERT_FILE", ""),
+		RedisTLSKeyFile:        getEnv("REDIS_TLS_KEY_FILE", ""),
+		RedisTLSServerName:     getEnv("REDIS_TLS_SERVER_NAME", ""),
+		RedisTLSInsecure:       getEnvAsBool("REDIS_TLS_INSECURE_SKIP_VERIFY", false),
+		Port:                   getEnvAsInt("PORT", 8080),
+		ReadTimeout:            time.Duration(getEnvAsInt("HTTP_READ_TIMEOUT", 30)) * time.Second,
+		WriteTimeout:           time.Duration(getEnvAsInt("HTTP_WRITE_TIMEOUT", 30)) * time.Second,
+		IdleTimeout:            time.Duration(getEnvAsInt("HTTP_IDLE_TIMEOUT", 60)) * time.Second,
+		MaxHeaderBytes:         getEnvAsInt("HTTP_MAX_HEADER_BYTES", 1<<20), // 1MB
+		ShutdownTimeout:        getEnvAsInt("SHUTDOWN_TIMEOUT", 30),
+		ResponseTimeout:        getEnvAsInt("RESPONSE_TIMEOUT", 30),
+		StreamingIdleTimeout:   getEnvAsInt("STREAMING_IDLE_TIMEOUT", 30),
+		StreamingMaxDuration:   getEnvAsInt("STREAMING_MAX_DURATION", 300),
+		CancelTopicSuffix:      getEnv("CANCEL_TOPIC_SUFFIX", ":cancel"),
+		ConfigReloadInterval:   getEnvAsInt("CONFIG_RELOAD_INTERVAL", 5),
+		RoutesFile:             getEnv("ROUTES_FILE", ""),
+		UnmatchedRouteStatus:   getEnvAsInt("UNMATCHED_ROUTE_STATUS", http.StatusNotFound),
+		AuthFile:               getEnv("AUTH_FILE", ""),
+		Broker:                 strings.ToLower(getEnv("BROKER", BrokerRedis)),
+		MemoryBrokerEcho:       getEnvAsBool("MEMORY_BROKER_ECHO", true),
+		HealthPath:             getEnv("HEALTH_PATH", ""),
+		MetricsPath:            getEnv("METRICS_PATH", "/metrics"),
+		MetricsMaxTopics:       getEnvAsInt("METRICS_MAX_TOPICS", 100),
+		TracingExporter:        strings.ToLower(getEnv("TRACING_EXPORTER", TracingExporterNone)),
+		TracingEndpoint:        getEnv("TRACING_ENDPOINT", ""),
+		TracingServiceName:     getEnv("TRACING_SERVICE_NAME", "async-proxy"),
+		NATSURL:                getEnv("NATS_URL", "nats://localhost:4222"),
+		NATSCredsFile:          getEnv("NATS_CREDS_FILE", ""),
+		NATSJobBucket:          getEnv("NATS_JOB_BUCKET", "proxy_jobs"),
+		Transport:              strings.ToLower(getEnv("TRANSPORT", TransportPubSub)),
+		StreamMaxLen:           int64(getEnvAsInt("STREAM_MAXLEN", 10000)),
+		AsyncJobs:              getEnvAsBool("ASYNC_JOBS", false),
+		JobsPath:               getEnv("JOBS_PATH", "/jobs/"),
+		JobTimeout:             getEnvAsInt("JOB_TIMEOUT", 300),
+		JobTTL:                 getEnvAsInt("JOB_TTL", 3600),
+		JobKeyPrefix:           getEnv("JOB_KEY_PREFIX", "proxy:job"),
+		CallbackHeader:         getEnv("CALLBACK_HEADER", "X-Callback-URL"),
+		CallbackSecret:         getEnv("CALLBACK_SECRET", ""),
+		CallbackTimeout:        getEnvAsInt("CALLBACK_TIMEOUT", 300),
+		CallbackRequestTimeout: getEnvAsInt("CALLBACK_REQUEST_TIMEOUT", 10),
+		CallbackMaxAttempts:    getEnvAsInt("CALLBACK_MAX_ATTEMPTS", 5),
+		CallbackRetryBaseMs:    getEnvAsInt("CALLBACK_RETRY_BASE_MS", 1000),
+		CallbackAllowedHosts:   getEnvAsList("CALLBACK_ALLOWED_HOSTS"),
+		WebSocketPath:          getEnv("WEBSOCKET_PATH", ""),
+		WebSocketPingInterval:  getEnvAsInt("WEBSOCKET_PING_INTERVAL", 30),
+		WebSocketPongTimeout:   getEnvAsInt("WEBSOCKET_PONG_TIMEOUT", 60),
+		WebSocketMaxFrameSize:  int64(getEnvAsInt("WEBSOCKET_MAX_FRAME_SIZE", 1<<20)), // 1MB
+		WebSocketOrigins:       getEnvAsList("WEBSOCKET_ALLOWED_ORIGINS"),
+		FailOnNoSubscribers:    getEnvAsBool("FAIL_ON_NO_SUBSCRIBERS", true),
+		NoSubscriberGraceMs:    getEnvAsInt("NO_SUBSCRIBER_GRACE_MS", 0),
+		InstanceID:             getEnv("PROXY_INSTANCE_ID", defaultInstanceID()),
+		ReplyPrefix:            getEnv("REPLY_PREFIX", "proxy:reply"),
+		ReplyShards:            getEnvAsInt("REPLY_SHARDS", 1),
+		LogLevel:               getEnvAsLogLevel("LOG_LEVEL", "info"),
+		DBLogPath:              getEnv("DB_LOG_PATH", ""),
+		DBMaxEntries:           getEnvAsInt("DB_MAX_ENTRIES", 0),
 	}
 
 	// Support DEBUG environment variable for backward compatibility
@@ -87,6 +234,8 @@ func LoadConfigFromEnv() Config {
 	redisDB, err := strconv.Atoi(getEnv("REDIS_DB", "0"))
 	if err == nil {
 		config.RedisDB = redisDB
+	} else {
+		invalidSetting("REDIS_DB", getEnv("REDIS_DB", "0"), "an integer")
 	}
 
 	// Optional fixed topic
@@ -98,15 +247,62 @@ func LoadConfigFromEnv() Config {
 		statusCode, err := strconv.Atoi(respondStatus)
 		if err == nil {
 			config.RespondImmediatelyStatus = statusCode
+		} else {
+			invalidSetting("RESPOND_IMMEDIATELY_STATUS_CODE", respondStatus, "a status code")
 		}
 	}
 
+	if config.UnmatchedRouteStatus < 100 || config.UnmatchedRouteStatus > 599 {
+		config.UnmatchedRouteStatus = http.StatusNotFound
+	}
+
+	if config.ReplyShards < 1 {
+		config.ReplyShards = 1
+	}
+
+	if config.CallbackMaxAttempts < 1 {
+		config.CallbackMaxAttempts = 1
+	}
+	if config.CallbackRetryBaseMs < 1 {
+		config.CallbackRetryBaseMs = 1
+	}
+
+	// The jobs path is registered as a subtree, so it needs both slashes
+	config.JobsPath = "/" + strings.Trim(config.JobsPath, "/") + "/"
+
+	if config.WebSocketPath != "" {
+		config.WebSocketPath = "/" + strings.Trim(config.WebSocketPath, "/") + "/"
+	}
+	if config.WebSocketPingInterval < 1 {
+		config.WebSocketPingInterval = 1
+	}
+	if config.WebSocketPongTimeout <= config.WebSocketPingInterval {
+		config.WebSocketPongTimeout = 2 * config.WebSocketPingInterval
+	}
+
 	return config
 }
 
-// Helper function to get environment variable with a default value
+// defaultInstanceID builds an instance ID from the hostname and a random suffix,
+// so that several proxies on the same host don't share reply topics. It is built
+// once, so configuration reloads keep the ID.
+var defaultInstanceID = sync.OnceValue(func() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "proxy"
+	}
+	return hostname + "-" + uuid.New().String()[:8]
+})
+
+// Helper function to get environment variable with a default value. While a
+// config file is loaded, its settings are used for unset environment variables.
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
+	if fileSettings != nil {
+		if fileValue, ok := fileSettings.lookup(key); ok && value == "" {
+			value = fileValue
+		}
+	}
 	if value == "" {
 		return defaultValue
 	}
@@ -121,6 +317,7 @@ func getEnvAsInt(key string, defaultValue int) int {
 	}
 	value, err := strconv.Atoi(valueStr)
 	if err != nil {
+		invalidSetting(key, valueStr, "an integer")
 		return defaultValue
 	}
 	return value
@@ -134,11 +331,38 @@ func getEnvAsBool(key string, defaultValue bool) bool {
 	}
 	value, err := strconv.ParseBool(valueStr)
 	if err != nil {
+		invalidSetting(key, valueStr, "true or false")
 		return defaultValue
 	}
 	return value
 }
 
+// Helper function to get a comma-separated environment variable as a list
+func getEnvAsList(key string) []string {
+	return splitList(getEnv(key, ""))
+}
+
+// splitList splits a comma-separated list, skipping empty items
+func splitList(list string) []string {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// Helper function to get environment variable as log level with a default value
+func getEnvAsLogLevel(key, defaultValue string) zerolog.Level {
+	valueStr := getEnv(key, defaultValue)
+	level := getLogLevel(valueStr)
+	if level == zerolog.InfoLevel && !strings.EqualFold(valueStr, "info") {
+		invalidSetting(key, valueStr, "trace, debug, info, warn, error, fatal or panic")
+	}
+	return level
+}
+
 // getLogLevel converts a string log level to zerolog.Level
 func getLogLevel(level string) zerolog.Level {
 	switch strings.ToLower(level) {
@@ -179,22 +403,109 @@ func truncateString(s string, maxLen int) string {
 	return s[:maxLen] + "..."
 }
 
-// extractResponseBody attempts to extract a response body from various formats
-func extractResponseBody(payload string) (interface{}, error) {
-	// First, try standard Response format
-	var response Response
-	if err := json.Unmarshal([]byte(payload), &response); err == nil {
-		if response.Body != nil {
-			return response.Body, nil
+// hopByHopHeaders are response headers a backend is not allowed to set,
+// since they are managed by the HTTP server itself
+var hopByHopHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"Keep-Alive":        true,
+	"Te":                true,
+	"Trailer":           true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+// parseResponse parses a reply payload into a Response. Replies that carry a
+// "body" key or a numeric "status" are treated as a response envelope; any
+// other payload is used as the body directly, as legacy replies did.
+func parseResponse(payload string) (*Response, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(payload), &fields); err == nil && isResponseEnvelope(fields) {
+		var response Response
+		if err := json.Unmarshal([]byte(payload), &response); err != nil {
+			return nil, fmt.Errorf("invalid response envelope: %w", err)
+		}
+		if err := validateResponse(&response); err != nil {
+			return nil, err
 		}
+		return &response, nil
 	}
 
-	// Try parsing as direct JSON object
+	// Try parsing as direct JSON value
 	var directJSON interface{}
 	if err := json.Unmarshal([]byte(payload), &directJSON); err == nil {
-		return directJSON, nil
+		return &Response{Status: http.StatusOK, Body: directJSON}, nil
+	}
+
+	// If we can't parse it as JSON at all, use the raw string
+	return &Response{Status: http.StatusOK, Body: payload}, nil
+}
+
+// isResponseEnvelope reports whether the decoded reply fields look like a Response
+func isResponseEnvelope(fields map[string]json.RawMessage) bool {
+	if _, ok := fields["body"]; ok {
+		return true
 	}
+	status, ok := fields["status"]
+	if !ok {
+		return false
+	}
+	var code int
+	return json.Unmarshal(status, &code) == nil
+}
 
-	// If we can't parse it as JSON at all, return the raw string
-	return payload, nil
+// validateResponse checks the status code and headers of a response envelope,
+// filling in defaults and dropping headers the backend may not set
+func validateResponse(response *Response) error {
+	if response.Status == 0 {
+		response.Status = http.StatusOK
+	}
+	if response.Status < 100 || response.Status > 599 {
+		return fmt.Errorf("invalid status code %d in response", response.Status)
+	}
+
+	for name, value := range response.Headers {
+		canonical := http.CanonicalHeaderKey(name)
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") || hopByHopHeaders[canonical] {
+			delete(response.Headers, name)
+			continue
+		}
+		if _, err := headerValues(value); err != nil {
+			return fmt.Errorf("invalid value for header %q: %w", name, err)
+		}
+	}
+
+	if strings.ContainsAny(response.ContentType, "\r\n") {
+		return fmt.Errorf("invalid content type %q in response", response.ContentType)
+	}
+
+	return nil
+}
+
+// headerValues converts a header value from a response envelope into a list of strings
+func headerValues(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("header value contains line break")
+		}
+		return []string{v}, nil
+	case float64, bool:
+		return []string{fmt.Sprint(v)}, nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if _, nested := item.([]interface{}); nested {
+				return nil, fmt.Errorf("nested header value lists are not supported")
+			}
+			itemValues, err := headerValues(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, itemValues...)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unsupported header value type %T", value)
+	}
 }
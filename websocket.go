@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// websocketWriteWait is the time allowed to write a frame to a WebSocket client
+const websocketWriteWait = 10 * time.Second
+
+// WebSocketSession represents a WebSocket client connection bridged to a topic
+type WebSocketSession struct {
+	ID             int64      `json:"id"`
+	SessionID      string     `json:"session_id"`
+	Path           string     `json:"path"`
+	Topic          string     `json:"topic"`
+	RemoteAddr     string     `json:"remote_addr"`
+	ConnectedAt    time.Time  `json:"connected_at"`
+	DisconnectedAt *time.Time `json:"disconnected_at,omitempty"`
+	MessagesIn     int64      `json:"messages_in"`
+	MessagesOut    int64      `json:"messages_out"`
+	Error          string     `json:"error,omitempty"`
+}
+
+// newWebSocketUpgrader creates the upgrader for the WebSocket endpoint. Without
+// configured origins only same-origin clients may connect, "*" allows any origin.
+func newWebSocketUpgrader(config Config) *websocket.Upgrader {
+	upgrader := &websocket.Upgrader{}
+	if len(config.WebSocketOrigins) == 0 {
+		return upgrader
+	}
+
+	upgrader.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, allowed := range config.WebSocketOrigins {
+			if allowed == "*" || strings.EqualFold(allowed, origin) {
+				return true
+			}
+		}
+		return false
+	}
+	return upgrader
+}
+
+// handleWebSocket bridges a WebSocket connection to Redis. Inbound frames are
+// published to the path-based topic, and everything published to the reply topic
+// of the session is pushed back to the client.
+func (ps *ProxyServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// Increment wait group counter for graceful shutdown
+	ps.wg.Add(1)
+	defer ps.wg.Done()
+
+	plan := ps.planRequest(r)
+	if plan == nil {
+		log.Warn().Str("path", r.URL.Path).Msg("No matching route for WebSocket connection")
+		http.Error(w, fmt.Sprintf("no route for %s %s", r.Method, r.URL.Path), ps.cfg().UnmatchedRouteStatus)
+		return
+	}
+	topic := plan.topic
+	headers := requestHeaders(r)
+	if auth := ps.cfg().Auth; auth != nil {
+		identity, status, err := auth.Authenticate(r, nil, plan.routeName(), topic)
+		if err != nil {
+			log.Warn().Err(err).Str("path", r.URL.Path).Int("status", status).Msg("WebSocket connection rejected")
+			http.Error(w, err.Error(), status)
+			return
+		}
+		auth.forward(headers, identity)
+	}
+	if plan.route != nil {
+		headers["path_params"] = plan.route.Params
+		if plan.route.Route.Name != "" {
+			headers["route"] = plan.route.Route.Name
+		}
+	}
+
+	conn, err := ps.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already responded to the client
+		log.Warn().Err(err).Str("path", r.URL.Path).Msg("WebSocket upgrade failed")
+		return
+	}
+
+	session := &WebSocketSession{
+		SessionID:   uuid.New().String(),
+		Path:        r.URL.Path,
+		Topic:       topic,
+		RemoteAddr:  r.RemoteAddr,
+		ConnectedAt: time.Now(),
+	}
+	logger := log.With().Str("sessionID", session.SessionID).Str("path", r.URL.Path).Logger()
+
+	// Register for replies before the client can send anything
+	waiter := ps.broker.AwaitReply(session.SessionID)
+	defer waiter.Close()
+
+	active := ps.websocketConnections.Add(1)
+	defer ps.websocketConnections.Add(-1)
+	logger.Debug().Str("topic", topic).Int64("active", active).Msg("WebSocket session opened")
+
+	if ps.dbLogger != nil && ps.dbLogger.enabled {
+		if err := ps.dbLogger.LogWebSocketSession(session); err != nil {
+			logger.Error().Err(err).Msg("Failed to log WebSocket session")
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ps.websocketCtx)
+	errorFrames := make(chan []byte, replyBufferSize)
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		ps.writeWebSocket(ctx, logger, conn, session, waiter, errorFrames)
+	}()
+
+	readErr := ps.readWebSocket(ctx, logger, conn, session, headers, waiter, errorFrames)
+	cancel()
+	<-writerDone
+
+	now := time.Now()
+	session.DisconnectedAt = &now
+	if readErr != nil {
+		session.Error = readErr.Error()
+		logger.Warn().Err(readErr).Msg("WebSocket session closed with error")
+	}
+	logger.Debug().Int64("messagesIn", session.MessagesIn).Int64("messagesOut", session.MessagesOut).
+		Msg("WebSocket session closed")
+
+	if ps.dbLogger != nil && ps.dbLogger.enabled {
+		if err := ps.dbLogger.LogWebSocketSession(session); err != nil {
+			logger.Error().Err(err).Msg("Failed to log WebSocket session")
+		}
+	}
+}
+
+// readWebSocket publishes inbound frames until the connection is closed. It
+// returns nil if the client or the proxy closed the connection normally.
+func (ps *ProxyServer) readWebSocket(ctx context.Context, logger zerolog.Logger, conn *websocket.Conn, session *WebSocketSession,
+	headers map[string]interface{}, waiter *ReplyWaiter, errorFrames chan<- []byte) error {
+
+	pongTimeout := time.Duration(ps.cfg().WebSocketPongTimeout) * time.Second
+	conn.SetReadLimit(ps.cfg().WebSocketMaxFrameSize)
+	conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+
+	for {
+		frameType, data, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil || websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return nil
+			}
+			return err
+		}
+		// Any frame shows the client is alive
+		conn.SetReadDeadline(time.Now().Add(pongTimeout))
+
+		requestID := uuid.New().String()
+		message := Message{Header: maps.Clone(headers)}
+		message.Header["request_id"] = requestID
+		message.Header["session_id"] = session.SessionID
+		message.Header["response_topic"] = waiter.Topic
+
+		if frameType == websocket.BinaryMessage {
+			message.Header["encoding"] = "base64"
+			message.Body = base64.StdEncoding.EncodeToString(data)
+		} else if err := json.Unmarshal(data, &message.Body); err != nil {
+			// If not valid JSON, use as string
+			message.Body = string(data)
+		}
+
+		if err := ps.publish(ctx, logger, session.Topic, message); err != nil {
+			logger.Error().Err(err).Str("requestID", requestID).Msg("Error publishing WebSocket frame")
+
+			reason := "error publishing to Redis"
+			if errors.Is(err, ErrNoSubscribers) {
+				reason = "no backend subscribed to topic " + session.Topic
+			}
+			frame, _ := json.Marshal(map[string]string{"request_id": requestID, "error": reason})
+			select {
+			case errorFrames <- frame:
+			default:
+			}
+			continue
+		}
+
+		session.MessagesIn++
+	}
+}
+
+// writeWebSocket pushes replies and error frames to the client and keeps the
+// connection alive with pings. It closes the connection when it returns.
+func (ps *ProxyServer) writeWebSocket(ctx context.Context, logger zerolog.Logger, conn *websocket.Conn, session *WebSocketSession,
+	waiter *ReplyWaiter, errorFrames <-chan []byte) {
+
+	defer conn.Close()
+
+	ticker := time.NewTicker(time.Duration(ps.cfg().WebSocketPingInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		var frame []byte
+		select {
+		case payload := <-waiter.Replies():
+			frame = []byte(payload)
+			session.MessagesOut++
+		case frame = <-errorFrames:
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(websocketWriteWait)); err != nil {
+				logger.Debug().Err(err).Msg("WebSocket ping failed")
+				return
+			}
+			continue
+		case <-ctx.Done():
+			// Tell the client why the connection goes away when the proxy shuts down
+			if ps.websocketCtx.Err() != nil {
+				closeMessage := websocket.FormatCloseMessage(websocket.CloseGoingAway, "proxy shutting down")
+				conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(websocketWriteWait))
+			}
+			return
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(websocketWriteWait))
+		if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+			logger.Debug().Err(err).Msg("Error writing WebSocket frame")
+			return
+		}
+	}
+}
+
+// LogWebSocketSession records a WebSocket session when it opens and updates it when it closes
+func (l *DBLogger) LogWebSocketSession(session *WebSocketSession) error {
+	if !l.enabled {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if session.ID != 0 {
+		_, err := l.db.Exec(`
+			UPDATE websocket_sessions
+			SET disconnected_at = ?, messages_in = ?, messages_out = ?, error = ?
+			WHERE id = ?
+		`, session.DisconnectedAt, session.MessagesIn, session.MessagesOut, session.Error, session.ID)
+		return err
+	}
+
+	result, err := l.db.Exec(`
+		INSERT INTO websocket_sessions (session_id, path, topic, remote_addr, connected_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, session.SessionID, session.Path, session.Topic, session.RemoteAddr, session.ConnectedAt)
+	if err != nil {
+		return err
+	}
+	if session.ID, err = result.LastInsertId(); err != nil {
+		return err
+	}
+
+	// Keep the session log bounded like the request log
+	_, err = l.db.Exec(`
+		DELETE FROM websocket_sessions
+		WHERE id <= (SELECT id FROM websocket_sessions ORDER BY id DESC LIMIT 1 OFFSET ?)
+	`, l.maxEntries)
+	return err
+}
+
+// CloseStaleWebSocketSessions marks sessions left open by a previous run of the proxy as closed
+func (l *DBLogger) CloseStaleWebSocketSessions() error {
+	if !l.enabled {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	_, err := l.db.Exec(`
+		UPDATE websocket_sessions
+		SET disconnected_at = ?, error = 'proxy restarted'
+		WHERE disconnected_at IS NULL
+	`, time.Now())
+	return err
+}
+
+// countWebSocketSessions returns the number of open sessions, and the number of
+// sessions and frames in the given range, where a zero time leaves the range open.
+// Callers must hold the mutex.
+func (l *DBLogger) countWebSocketSessions(ctx context.Context, from, to time.Time) (active, sessions int, messagesIn, messagesOut int64, err error) {
+	err = l.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM websocket_sessions WHERE disconnected_at IS NULL").Scan(&active)
+	if err != nil {
+		return
+	}
+
+	query := "SELECT COUNT(*), SUM(messages_in), SUM(messages_out) FROM websocket_sessions WHERE 1 = 1"
+	var args []interface{}
+	if !from.IsZero() {
+		query += " AND connected_at >= ?"
+		args = append(args, from)
+	}
+	if !to.IsZero() {
+		query += " AND connected_at < ?"
+		args = append(args, to)
+	}
+
+	var in, out sql.NullInt64
+	err = l.db.QueryRowContext(ctx, query, args...).Scan(&sessions, &in, &out)
+	return active, sessions, in.Int64, out.Int64, err
+}